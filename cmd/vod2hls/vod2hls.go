@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/eliteprox/lpms/ffmpeg"
+	"github.com/livepeer/m3u8"
+)
+
+func main() {
+	segment := flag.Duration("segment", 2*time.Second, "Target segment duration")
+	chunks := flag.Int("chunks", 0, "Number of chunks to split the input into for transcoding; defaults to the number of CPUs")
+	noAudio := flag.Bool("noaudio", false, "Drop the audio track")
+	flag.Parse()
+	args := append([]string{os.Args[0]}, flag.Args()...)
+	if len(args) <= 3 {
+		panic("Usage: [-segment dur] [-chunks n] [-noaudio] <input file> <profiles json file> <output dir>")
+	}
+	fname := args[1]
+	outDir := args[3]
+
+	data, err := ioutil.ReadFile(args[2])
+	if err != nil {
+		panic(err)
+	}
+	profiles, err := ffmpeg.ParseProfiles(data)
+	if err != nil {
+		panic(err)
+	}
+	if len(profiles) == 0 {
+		panic("No profiles given")
+	}
+	workDir, err := ioutil.TempDir("", "vod2hls")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(workDir)
+
+	ffmpeg.InitFFmpeg()
+
+	t := time.Now()
+	options := make([]ffmpeg.TranscodeOptions, len(profiles))
+	for i, p := range profiles {
+		options[i] = ffmpeg.TranscodeOptions{
+			Oname:   filepath.Join(workDir, fmt.Sprintf("rendition_%d.ts", i)),
+			Profile: p,
+			Accel:   p.Accel,
+		}
+	}
+	audio := ffmpeg.ComponentOptions{}
+	if *noAudio {
+		audio.Name = "drop"
+	}
+	fmt.Printf("Transcoding %s into %d renditions\n", fname, len(options))
+	// keyframes of all renditions at every segment boundary, so that the
+	// segments line up
+	in := &ffmpeg.TranscodeOptionsIn{
+		Fname:            fname,
+		KeyframeAlign:    ffmpeg.KeyframeAlignTime,
+		KeyframeInterval: *segment,
+	}
+	res, err := ffmpeg.TranscodeVOD(in, options, ffmpeg.VODOptions{Chunks: *chunks, AudioEncoder: audio})
+	if err != nil {
+		panic(err)
+	}
+
+	master := m3u8.NewMasterPlaylist()
+	for i, p := range profiles {
+		name := p.Name
+		if name == "" {
+			name = strconv.Itoa(i)
+		}
+		dir := filepath.Join(outDir, name)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			panic(err)
+		}
+		_, err := ffmpeg.Transcode3(&ffmpeg.TranscodeOptionsIn{
+			Fname:       options[i].Oname,
+			Transmuxing: true,
+		}, []ffmpeg.TranscodeOptions{{
+			Oname:        filepath.Join(dir, "index.m3u8"),
+			Profile:      ffmpeg.VideoProfile{Format: ffmpeg.FormatNone},
+			VideoEncoder: ffmpeg.ComponentOptions{Name: "copy"},
+			AudioEncoder: ffmpeg.ComponentOptions{Name: "copy"},
+			Muxer: ffmpeg.ComponentOptions{Name: "hls", Opts: map[string]string{
+				"hls_time":             strconv.FormatFloat(segment.Seconds(), 'f', -1, 64),
+				"hls_playlist_type":    "vod",
+				"hls_segment_filename": filepath.Join(dir, "%05d.ts"),
+			}},
+		}})
+		if err != nil {
+			panic(err)
+		}
+
+		// describe the rendition as encoded: the aspect ratio is preserved, and
+		// the level is up to the encoder
+		_, format, err := ffmpeg.GetCodecInfo(options[i].Oname)
+		if err != nil {
+			panic(err)
+		}
+		p.Resolution = fmt.Sprintf("%dx%d", format.Width, format.Height)
+		params := ffmpeg.VideoProfileToVariantParams(p)
+		params.Codecs = ffmpeg.FormatCodecs(format)
+		bandwidth, err := peakBandwidth(filepath.Join(dir, "index.m3u8"))
+		if err != nil {
+			panic(err)
+		}
+		if bandwidth > 0 {
+			params.Bandwidth = bandwidth
+		}
+		master.Append(name+"/index.m3u8", nil, params)
+		fmt.Printf("profile=%v frames=%v resolution=%v codecs=%v bandwidth=%v\n", name, res.Encoded[i].Frames, params.Resolution, params.Codecs, params.Bandwidth)
+	}
+	if err := ioutil.WriteFile(filepath.Join(outDir, "master.m3u8"), master.Encode().Bytes(), 0644); err != nil {
+		panic(err)
+	}
+	fmt.Printf("Packaging time %0.4v\n", time.Since(t).Seconds())
+}
+
+// peakBandwidth returns the highest bitrate of the segments of a media
+// playlist, audio and muxing overhead included, as BANDWIDTH calls for
+func peakBandwidth(fname string) (uint32, error) {
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return 0, err
+	}
+	pl, err := m3u8.NewMediaPlaylist(50000, 50000)
+	if err != nil {
+		return 0, err
+	}
+	if err := pl.DecodeFrom(bytes.NewReader(data), true); err != nil {
+		return 0, err
+	}
+	var peak float64
+	for _, seg := range pl.Segments {
+		if seg == nil || seg.Duration <= 0 {
+			continue
+		}
+		info, err := os.Stat(filepath.Join(filepath.Dir(fname), seg.URI))
+		if err != nil {
+			return 0, err
+		}
+		if bps := float64(info.Size()*8) / seg.Duration; bps > peak {
+			peak = bps
+		}
+	}
+	return uint32(peak), nil
+}
@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/eliteprox/lpms/ffmpeg"
+	"github.com/eliteprox/lpms/metrics"
 	"github.com/eliteprox/lpms/segmenter"
 	"github.com/eliteprox/lpms/stream"
 	"github.com/eliteprox/lpms/vidlistener"
@@ -182,7 +183,10 @@ func (l *LPMS) SegmentRTMPToHLS(ctx context.Context, rs stream.RTMPVideoStream,
 
 				ss := stream.HLSSegment{SeqNo: seg.SeqNo, Data: seg.Data, Name: seg.Name, Duration: seg.Length.Seconds()}
 				// glog.Infof("Writing stream: %v, duration:%v, len:%v", ss.Name, ss.Duration, len(seg.Data))
-				if err = hs.AddHLSSegment(&ss); err != nil {
+				addStart := time.Now()
+				err = hs.AddHLSSegment(&ss)
+				metrics.Current().HLSSegmentAdd(time.Since(addStart))
+				if err != nil {
 					glog.Errorf("Error adding segment: %v", err)
 				}
 				select {
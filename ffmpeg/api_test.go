@@ -912,6 +912,8 @@ func encodingProfiles(t *testing.T, accel Acceleration) {
 			profilesMap[v] = "high"
 		case ProfileH264ConstrainedHigh:
 			profilesMap[v] = "constrained_high"
+		case ProfileH265Main, ProfileH265Main10:
+			// HEVC profiles are checked below
 		default:
 			t.Error("Unhandled profile ", v)
 		}
@@ -989,6 +991,51 @@ func encodingProfiles(t *testing.T, accel Acceleration) {
 	`
 	run(cmd)
 
+	// HEVC Main and an explicit H.264 level
+	hevc := P144p30fps16x9
+	hevc.Encoder = H265
+	hevc.Profile = ProfileH265Main
+	leveled := P144p30fps16x9
+	leveled.Profile = ProfileH264High
+	leveled.Level = 31
+	for name, profile := range map[string]VideoProfile{"hevc_main": hevc, "level": leveled} {
+		tc := NewTranscoder()
+		in := &TranscodeOptionsIn{Fname: fmt.Sprintf("%s/test.ts", dir), Accel: accel}
+		out := []TranscodeOptions{{
+			Oname:   fmt.Sprintf("%s/out_%s.mp4", dir, name),
+			Accel:   accel,
+			Profile: profile,
+		}}
+		_, err := tc.Transcode(in, out)
+		require.NoError(t, err)
+		tc.StopTranscoder()
+	}
+	cmd = `
+		ffprobe -loglevel warning -show_streams out_hevc_main.mp4 | grep "profile=Main$"
+		ffprobe -loglevel warning -show_streams out_level.mp4 | grep "profile=High$"
+		ffprobe -loglevel warning -show_streams out_level.mp4 | grep "level=31"
+	`
+	run(cmd)
+
+	// Profiles not matching the codec or color depth
+	mismatched := []VideoProfile{hevc, hevc, leveled}
+	mismatched[0].Encoder = H264
+	mismatched[1].ColorDepth = ColorDepth10Bit
+	mismatched[2].Profile = ProfileNone
+	mismatched[2].Encoder = H265
+	for _, profile := range mismatched {
+		tc := NewTranscoder()
+		in := &TranscodeOptionsIn{Fname: fmt.Sprintf("%s/test.ts", dir), Accel: accel}
+		out := []TranscodeOptions{{
+			Oname:   fmt.Sprintf("%s/out_dummy.mp4", dir),
+			Accel:   accel,
+			Profile: profile,
+		}}
+		_, err := tc.Transcode(in, out)
+		require.Equal(t, ErrTranscoderPrf, err)
+		tc.StopTranscoder()
+	}
+
 	// Unknown profile
 	tc := NewTranscoder()
 	profile := P144p30fps16x9
@@ -1293,6 +1340,41 @@ func TestTranscoder_OutputFPS(t *testing.T) {
 	outputFPS(t, Software)
 }
 
+func TestTranscoder_FramerateCap(t *testing.T) {
+	run, dir := setupTest(t)
+	defer os.RemoveAll(dir)
+
+	cmd := `
+	# 24fps copy of the 60fps test segment
+	ffmpeg -loglevel warning -i "$1"/../transcoder/test.ts -vf fps=24 -c:a copy -c:v libx264 -f mpegts test24fps.ts
+  `
+	run(cmd)
+
+	capped := P144p30fps16x9
+	capped.FramerateMode = FramerateMax
+	transcode := func(fname, oname string) *TranscodeResults {
+		res, err := Transcode3(&TranscodeOptionsIn{Fname: fname}, []TranscodeOptions{{
+			Oname:   oname,
+			Profile: capped,
+		}})
+		require.NoError(t, err)
+		return res
+	}
+
+	// below the cap, the source cadence is kept rather than frames duplicated
+	res := transcode(dir+"/test24fps.ts", dir+"/out24fps.ts")
+	require.Equal(t, res.Decoded.Frames, res.Encoded[0].Frames)
+	// above the cap, frames are dropped down to it
+	res = transcode("../transcoder/test.ts", dir+"/out30fps.ts")
+	require.Equal(t, 480, res.Decoded.Frames)
+	require.InDelta(t, 240, res.Encoded[0].Frames, 1)
+
+	cmd = `
+    ffprobe -loglevel warning -select_streams v -show_streams out24fps.ts | grep avg_frame_rate=24/1
+  `
+	run(cmd)
+}
+
 func TestTranscoderAPI_ClipInvalidConfig(t *testing.T) {
 	run, dir := setupTest(t)
 	cmd := `
@@ -1437,10 +1519,17 @@ func discontinuityPixelFormatSegment(t *testing.T, accel Acceleration) {
 			Profile: prof,
 			Accel:   accel,
 		}}
-		_, err := tc.Transcode(in, out)
+		res, err := tc.Transcode(in, out)
 		if err != nil {
 			t.Error(err)
+			continue
+		}
+		// pixel format changes between every segment
+		if i > 1 {
+			require.Equal(t, ConfigChangePixelFormat, res.ConfigChange)
+			require.True(t, res.Reinitialized)
 		}
+		require.NotZero(t, res.Encoded[0].Frames)
 	}
 	tc.StopTranscoder()
 }
@@ -1515,6 +1604,28 @@ func compareVideo(t *testing.T, accel Acceleration) {
 	if err != nil || res != false {
 		t.Error(err)
 	}
+
+	//test detailed comparison results
+	details, err := CompareVideoDetailsByBuffer(data1, data2, nil)
+	require.NoError(t, err)
+	require.True(t, details.Match)
+	require.Equal(t, CompareCheckNone, details.FailedCheck)
+	require.Equal(t, 1.0, details.Score)
+	require.Zero(t, details.UnmatchedPackets)
+	details, err = CompareVideoDetailsByBuffer(data1, data3, nil)
+	require.NoError(t, err)
+	require.False(t, details.Match)
+	require.Equal(t, CompareCheckDimensions, details.FailedCheck)
+	require.Equal(t, 256-1280, details.WidthDiff)
+	require.Equal(t, 144-720, details.HeightDiff)
+	// ignoring dimensions leaves only the audio check, which passes
+	th := DefaultVideoCompareThresholds
+	th.RequireSameDimensions = false
+	details, err = CompareVideoDetailsByPath(dir+"/out-1.ts", dir+"/out-3.ts", &th)
+	require.NoError(t, err)
+	require.True(t, details.Match)
+	_, err = CompareVideoDetailsByPath(dir+"/out-1.ts", dir+"/out-4.ts", nil)
+	require.Equal(t, ErrVideoCompare, err)
 }
 func TestTranscoder_CompareVideo(t *testing.T) {
 	compareVideo(t, Software)
@@ -1575,6 +1686,30 @@ func TestTranscoder_DetectionFreq(t *testing.T) {
 	detectionFreq(t, Software, "-1")
 }
 
+func TestTranscoder_DetectionReinit(t *testing.T) {
+	run, dir := setupTest(t)
+	defer os.RemoveAll(dir)
+	cmd := `
+    ffmpeg -loglevel warning -i "$1"/../transcoder/test.ts -t 2 -c copy seg0.ts
+    # a resolution change rebuilds the session
+    ffmpeg -loglevel warning -i seg0.ts -vf scale=640:360 -c:v libx264 -c:a copy seg1.ts
+  `
+	run(cmd)
+
+	InitFFmpeg()
+	tc, err := NewTranscoderWithDetector(&DSceneAdultSoccer, "-1")
+	require.NotNil(t, tc, "look for `Failed to load native model` logs above")
+	require.NoError(t, err)
+	defer tc.StopTranscoder()
+	for i := 0; i < 2; i++ {
+		in := &TranscodeOptionsIn{Fname: fmt.Sprintf("%s/seg%d.ts", dir, i)}
+		res, err := tc.Transcode(in, []TranscodeOptions{{Detector: &DSceneAdultSoccer}})
+		require.NoError(t, err)
+		require.Equal(t, i == 1, res.Reinitialized)
+		require.NotNil(t, res.Encoded[0].DetectData, "detection carries on after a reinit")
+	}
+}
+
 func discontinuityAudioSegment(t *testing.T, accel Acceleration) {
 	run, dir := setupTest(t)
 	defer os.RemoveAll(dir)
@@ -1625,3 +1760,144 @@ func discontinuityAudioSegment(t *testing.T, accel Acceleration) {
 func TestTranscoder_discontinuityAudioSegment(t *testing.T) {
 	discontinuityAudioSegment(t, Software)
 }
+
+func TestTranscoder_ConfigChange(t *testing.T) {
+	run, dir := setupTest(t)
+	defer os.RemoveAll(dir)
+
+	cmd := `
+	cp "$1/../transcoder/test.ts" test.ts
+
+	ffmpeg -loglevel warning -i test.ts -c:a copy -c:v copy -t 1 in-1.ts
+	# resolution change
+	ffmpeg -loglevel warning -i in-1.ts -c:a copy -c:v libx264 -vf scale=640:360 in-2.ts
+	# sample rate change
+	ffmpeg -loglevel warning -i in-2.ts -c:a aac -ar 22050 -c:v copy in-3.ts
+	# no change
+	cp in-3.ts in-4.ts
+	# audio codec change; handled without rebuilding the session
+	ffmpeg -loglevel warning -i in-4.ts -c:a mp2 -ar 22050 -c:v copy in-5.ts
+	`
+	run(cmd)
+
+	expected := []struct {
+		change ConfigChange
+		reinit bool
+	}{
+		{0, false},
+		{ConfigChangeResolution, true},
+		{ConfigChangeSampleRate, true},
+		{0, false},
+		{ConfigChangeAudioCodec, false},
+	}
+	tc := NewTranscoder()
+	defer tc.StopTranscoder()
+	for i, e := range expected {
+		in := &TranscodeOptionsIn{Fname: fmt.Sprintf("%s/in-%d.ts", dir, i+1)}
+		out := []TranscodeOptions{{
+			Oname:   fmt.Sprintf("%s/out-%d.ts", dir, i+1),
+			Profile: P144p30fps16x9,
+		}}
+		res, err := tc.Transcode(in, out)
+		require.NoError(t, err)
+		require.Equal(t, e.change, res.ConfigChange, "segment %d", i+1)
+		require.Equal(t, e.reinit, res.Reinitialized, "segment %d", i+1)
+		require.NotZero(t, res.Encoded[0].Frames)
+	}
+
+	// classification of the format changes themselves
+	prev := MediaFormatInfo{Vcodec: "h264", Acodec: "aac", Width: 1280, Height: 720,
+		SampleRate: 44100, Channels: 2, ChannelLayout: 3}
+	cur := prev
+	require.Equal(t, ConfigChange(0), classifyConfigChange(prev, cur))
+	cur.Vcodec = "hevc"
+	cur.ChannelLayout = 4
+	change := classifyConfigChange(prev, cur)
+	require.Equal(t, ConfigChangeVideoCodec|ConfigChangeChannelLayout, change)
+	require.True(t, change.Significant())
+	require.Equal(t, "VideoCodec,ChannelLayout", change.String())
+	// audio added or removed is not a change of audio parameters
+	cur = prev
+	cur.Acodec, cur.SampleRate = "", 0
+	require.False(t, classifyConfigChange(prev, cur).Significant())
+	require.False(t, ConfigChangeAudioCodec.Significant())
+}
+
+func TestTranscoder_ClipWindow(t *testing.T) {
+	run, dir := setupTest(t)
+	defer os.RemoveAll(dir)
+
+	cmd := `
+    cp "$1"/../transcoder/test.ts .
+    ffmpeg -loglevel warning -i test.ts -c copy -f segment seg%d.ts
+    ls seg*.ts | wc -l | grep 4 # sanity check number of segments
+  `
+	run(cmd)
+
+	_, format, err := GetCodecInfo(dir + "/seg0.ts")
+	require.NoError(t, err)
+	start := format.StartTime
+	pdt := time.Date(2021, 6, 1, 10, 2, 15, 0, time.UTC)
+
+	sourceRate := P144p30fps16x9
+	sourceRate.Framerate = 0
+	clip := func(name string, w ClipWindow, profile VideoProfile) []int {
+		frames := []int{}
+		tc := NewTranscoder()
+		defer tc.StopTranscoder()
+		for i := 0; i < 4; i++ {
+			fname := fmt.Sprintf("%s/seg%d.ts", dir, i)
+			_, format, err := GetCodecInfo(fname)
+			require.NoError(t, err)
+			in := &TranscodeOptionsIn{Fname: fname, ProgramDateTime: pdt.Add(format.StartTime - start)}
+			res, err := tc.Transcode(in, []TranscodeOptions{{
+				Oname:   fmt.Sprintf("%s/%s-%d.ts", dir, name, i),
+				Profile: profile,
+				Clip:    w,
+			}})
+			require.NoError(t, err)
+			frames = append(frames, res.Encoded[0].Frames)
+		}
+		return frames
+	}
+	check := func(frames []int, fps int) {
+		total := 0
+		for _, n := range frames {
+			total += n
+		}
+		// four seconds, starting within the first segment
+		require.InDelta(t, 4*fps, total, 2)
+		require.NotZero(t, frames[0])
+		require.Zero(t, frames[3])
+	}
+
+	check(clip("pts", ClipWindow{FromPTS: start + time.Second, ToPTS: start + 5*time.Second}, sourceRate), 60)
+	wallclock := ClipWindow{FromTime: pdt.Add(time.Second), ToTime: pdt.Add(5 * time.Second)}
+	check(clip("wallclock", wallclock, sourceRate), 60)
+	// nothing of segments past the window, whatever the output frame rate
+	check(clip("wallclock-30fps", wallclock, P144p30fps16x9), 30)
+
+	// timestamps are kept rather than rebased to zero
+	_, segFormat, err := GetCodecInfo(dir + "/seg1.ts")
+	require.NoError(t, err)
+	_, clipFormat, err := GetCodecInfo(dir + "/pts-1.ts")
+	require.NoError(t, err)
+	require.InDelta(t, segFormat.StartTime, clipFormat.StartTime, float64(200*time.Millisecond))
+
+	tc := NewTranscoder()
+	defer tc.StopTranscoder()
+	in := &TranscodeOptionsIn{Fname: dir + "/seg0.ts"}
+	_, err = tc.Transcode(in, []TranscodeOptions{{
+		Oname:   dir + "/invalid.ts",
+		Profile: P144p30fps16x9,
+		Clip:    ClipWindow{FromTime: pdt},
+	}})
+	require.Equal(t, ErrTranscoderClipConfig, err, "wall-clock window without program date time")
+	_, err = tc.Transcode(in, []TranscodeOptions{{
+		Oname:   dir + "/invalid.ts",
+		Profile: P144p30fps16x9,
+		From:    time.Second,
+		Clip:    ClipWindow{ToPTS: time.Second},
+	}})
+	require.Equal(t, ErrTranscoderClipConfig, err, "window combined with From")
+}
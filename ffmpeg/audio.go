@@ -0,0 +1,92 @@
+package ffmpeg
+
+// #include <stdlib.h>
+// #include "transcoder.h"
+import "C"
+
+import "unsafe"
+
+// AudioEncoderAuto is an AudioEncoder name that stream copies the input audio
+// if it is already what the output would encode: the same codec, at the
+// sample rate and channels of the audio filters. Otherwise the audio is
+// encoded as if no name was set. This is decided for every segment, so it
+// follows the input through audio codec changes.
+const AudioEncoderAuto = "auto"
+
+// Encoded audio always goes through filters converting to these
+const (
+	encodedAudioSampleRate = 44100
+	encodedAudioChannels   = 2
+)
+
+// defaultAudioEncoder is the encoder, and its options, of outputs that don't
+// name one
+func defaultAudioEncoder(profile AudioProfile, opts map[string]string) (string, map[string]string) {
+	name := ""
+	if len(opts) <= 0 {
+		name = profile.Codec
+		if profile.Bitrate != "" {
+			opts = map[string]string{"b": profile.Bitrate}
+		}
+	}
+	if name == "" {
+		name = "aac"
+	}
+	return name, opts
+}
+
+// hasAutoAudio is true if any output has its audio encoder set to
+// AudioEncoderAuto, so the audio has to be decoded throughout the session
+func hasAutoAudio(ps []TranscodeOptions) bool {
+	for _, p := range ps {
+		if p.AudioEncoder.Name == AudioEncoderAuto {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveAutoAudio picks between copying and encoding the audio of outputs
+// set to AudioEncoderAuto, given the probed format of the segment. The zero
+// format, for inputs that can't be probed, has them encode.
+func resolveAutoAudio(ps []TranscodeOptions, format MediaFormatInfo) []TranscodeOptions {
+	var resolved []TranscodeOptions
+	for i, p := range ps {
+		if p.AudioEncoder.Name != AudioEncoderAuto {
+			continue
+		}
+		if resolved == nil {
+			// leave the caller's options be
+			resolved = append([]TranscodeOptions(nil), ps...)
+		}
+		if canCopyAudio(p, format) {
+			resolved[i].AudioEncoder = ComponentOptions{Name: "copy"}
+		} else {
+			resolved[i].AudioEncoder.Name = ""
+		}
+	}
+	if resolved == nil {
+		return ps
+	}
+	return resolved
+}
+
+func canCopyAudio(p TranscodeOptions, format MediaFormatInfo) bool {
+	if format.Acodec == "" || format.SampleRate != encodedAudioSampleRate ||
+		format.Channels != encodedAudioChannels {
+		return false
+	}
+	encoder, _ := defaultAudioEncoder(p.Profile.Audio, p.AudioEncoder.Opts)
+	return sameAudioCodec(format.Acodec, encoder)
+}
+
+// sameAudioCodec is true if the named decoder and encoder are for the same codec
+func sameAudioCodec(decoder, encoder string) bool {
+	cdecoder := C.CString(decoder)
+	defer C.free(unsafe.Pointer(cdecoder))
+	cencoder := C.CString(encoder)
+	defer C.free(unsafe.Pointer(cencoder))
+	dec := C.avcodec_find_decoder_by_name(cdecoder)
+	enc := C.avcodec_find_encoder_by_name(cencoder)
+	return dec != nil && enc != nil && dec.id == enc.id
+}
@@ -0,0 +1,93 @@
+package ffmpeg
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveAutoAudio(t *testing.T) {
+	assert := assert.New(t)
+	aac := MediaFormatInfo{Acodec: "aac", SampleRate: 44100, Channels: 2}
+	ps := []TranscodeOptions{
+		{AudioEncoder: ComponentOptions{Name: AudioEncoderAuto}},
+		{AudioEncoder: ComponentOptions{Name: AudioEncoderAuto, Opts: map[string]string{"b": "64k"}}},
+		{AudioEncoder: ComponentOptions{Name: "drop"}},
+	}
+
+	resolved := resolveAutoAudio(ps, aac)
+	assert.Equal(ComponentOptions{Name: "copy"}, resolved[0].AudioEncoder)
+	assert.Equal(ComponentOptions{Name: "copy"}, resolved[1].AudioEncoder)
+	assert.Equal("drop", resolved[2].AudioEncoder.Name)
+	assert.Equal(AudioEncoderAuto, ps[0].AudioEncoder.Name, "caller's options untouched")
+
+	for _, format := range []MediaFormatInfo{
+		{Acodec: "aac", SampleRate: 48000, Channels: 2},
+		{Acodec: "aac", SampleRate: 44100, Channels: 1},
+		{Acodec: "mp3", SampleRate: 44100, Channels: 2},
+		{},
+	} {
+		resolved := resolveAutoAudio(ps, format)
+		assert.Equal("", resolved[0].AudioEncoder.Name, format)
+		assert.Equal(ps[1].AudioEncoder.Opts, resolved[1].AudioEncoder.Opts, "options kept for encoding")
+	}
+
+	// the profile's codec is what would be encoded
+	ps[0].Profile.Audio.Codec = "libmp3lame"
+	assert.Equal("", resolveAutoAudio(ps, aac)[0].AudioEncoder.Name)
+
+	ps = []TranscodeOptions{{AudioEncoder: ComponentOptions{Name: "copy"}}}
+	assert.Equal(ps, resolveAutoAudio(ps, aac))
+	assert.False(hasAutoAudio(ps))
+	assert.True(hasAutoAudio(append(ps, TranscodeOptions{AudioEncoder: ComponentOptions{Name: AudioEncoderAuto}})))
+}
+
+func TestTranscoder_AudioAuto(t *testing.T) {
+	audioAutoTest(t, Software)
+}
+
+func audioAutoTest(t *testing.T, accel Acceleration) {
+	run, dir := setupTest(t)
+	defer os.RemoveAll(dir)
+
+	cmd := `
+    ffmpeg -loglevel warning -f lavfi -i testsrc=size=320x240:rate=30 -f lavfi -i sine=sample_rate=44100 -t 2 -c:v libx264 -c:a aac -b:a 48k -ac 2 -f mpegts match.ts
+    # another codec keeps the session, unlike other sample rates or channels
+    ffmpeg -loglevel warning -f lavfi -i testsrc=size=320x240:rate=30 -f lavfi -i sine=sample_rate=44100 -t 2 -c:v libx264 -c:a mp2 -ac 2 -f mpegts codec.ts
+    ffmpeg -loglevel warning -f lavfi -i testsrc=size=320x240:rate=30 -f lavfi -i sine=sample_rate=48000 -t 2 -c:v libx264 -c:a aac -ac 1 -f mpegts mismatch.ts
+  `
+	run(cmd)
+
+	tc := NewTranscoder()
+	defer tc.StopTranscoder()
+	// copied first, so the session starts out without encoding audio
+	for i, seg := range []string{"match", "codec", "mismatch", "match"} {
+		_, err := tc.Transcode(&TranscodeOptionsIn{Fname: dir + "/" + seg + ".ts", Accel: accel}, []TranscodeOptions{{
+			Oname:        fmt.Sprintf("%s/out-%s-%d.ts", dir, seg, i),
+			Profile:      P144p30fps16x9,
+			Accel:        accel,
+			AudioEncoder: ComponentOptions{Name: AudioEncoderAuto},
+		}})
+		require.NoError(t, err, seg)
+	}
+
+	cmd = `
+    # matching audio is copied as is; the first packets may be dropped as preroll
+    ffprobe -loglevel warning -select_streams a -show_entries packet=size -of csv=p=0 match.ts | tail -n 20 > in.sizes
+    for out in out-match-0.ts out-match-3.ts; do
+      ffprobe -loglevel warning -select_streams a -show_entries packet=size -of csv=p=0 $out | tail -n 20 > out.sizes
+      diff -u in.sizes out.sizes
+    done
+
+    ffprobe -loglevel warning -select_streams a -show_streams out-codec-1.ts | grep codec_name=aac
+
+    ffprobe -loglevel warning -select_streams a -show_streams out-mismatch-2.ts > mismatch.out
+    grep codec_name=aac mismatch.out
+    grep sample_rate=44100 mismatch.out
+    grep channels=2 mismatch.out
+  `
+	run(cmd)
+}
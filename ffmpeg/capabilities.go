@@ -0,0 +1,135 @@
+package ffmpeg
+
+import (
+	"sort"
+	"unsafe"
+)
+
+// #include <stdlib.h>
+// #include "extras.h"
+// #include "transcoder.h"
+import "C"
+
+// CapabilityFilters are the filters LPMS relies on, whose presence is
+// reported by Capabilities
+var CapabilityFilters = []string{
+	"scale", "fps", "signature", "hwupload_cuda", "scale_cuda",
+	"signature_cuda", "lvpdnn",
+}
+
+// filters needed to scale video on the given acceleration
+var accelScaleFilters = map[Acceleration][]string{
+	Software: {"scale"},
+	Nvidia:   {"scale_cuda"},
+	Netint:   {"scale"},
+}
+
+// CapabilityInfo describes what the linked libav* libraries and the
+// available hardware support
+type CapabilityInfo struct {
+	Encoders      []string
+	Decoders      []string
+	Muxers        []string
+	Filters       map[string]bool
+	HWDeviceTypes []string
+	// Accelerations whose device could be opened
+	Accelerations []Acceleration
+	// VideoCodecs that can be encoded per usable acceleration
+	VideoCodecs map[Acceleration][]VideoCodec
+}
+
+// Capabilities asks libavcodec/libavutil which components are available and
+// probes the hardware devices used by the supported accelerations. Opening
+// devices is not free, so callers are expected to keep the result around.
+func Capabilities() *CapabilityInfo {
+	caps := &CapabilityInfo{
+		Filters:     make(map[string]bool),
+		VideoCodecs: make(map[Acceleration][]VideoCodec),
+	}
+	var it C.uintptr_t
+	for name := C.lpms_next_encoder(&it); name != nil; name = C.lpms_next_encoder(&it) {
+		caps.Encoders = append(caps.Encoders, C.GoString(name))
+	}
+	it = 0
+	for name := C.lpms_next_decoder(&it); name != nil; name = C.lpms_next_decoder(&it) {
+		caps.Decoders = append(caps.Decoders, C.GoString(name))
+	}
+	it = 0
+	for name := C.lpms_next_muxer(&it); name != nil; name = C.lpms_next_muxer(&it) {
+		caps.Muxers = append(caps.Muxers, C.GoString(name))
+	}
+	sort.Strings(caps.Encoders)
+	sort.Strings(caps.Decoders)
+	sort.Strings(caps.Muxers)
+	for _, f := range CapabilityFilters {
+		cname := C.CString(f)
+		caps.Filters[f] = C.lpms_has_filter(cname) != 0
+		C.free(unsafe.Pointer(cname))
+	}
+	hwTypes := make(map[C.enum_AVHWDeviceType]bool)
+	for t := C.av_hwdevice_iterate_types(C.AV_HWDEVICE_TYPE_NONE); t != C.AV_HWDEVICE_TYPE_NONE; t = C.av_hwdevice_iterate_types(t) {
+		hwTypes[t] = true
+		caps.HWDeviceTypes = append(caps.HWDeviceTypes, C.GoString(C.av_hwdevice_get_type_name(t)))
+	}
+
+	for _, accel := range []Acceleration{Software, Nvidia, Amd, Netint} {
+		if !caps.accelUsable(accel, hwTypes) {
+			continue
+		}
+		caps.Accelerations = append(caps.Accelerations, accel)
+		for _, codec := range []VideoCodec{H264, H265, VP8, VP9} {
+			encoder, ok := FfEncoderLookup[accel][codec]
+			if ok && caps.HasEncoder(encoder) {
+				caps.VideoCodecs[accel] = append(caps.VideoCodecs[accel], codec)
+			}
+		}
+	}
+	return caps
+}
+
+func (caps *CapabilityInfo) accelUsable(accel Acceleration, hwTypes map[C.enum_AVHWDeviceType]bool) bool {
+	filters, ok := accelScaleFilters[accel]
+	if !ok {
+		return false
+	}
+	for _, f := range filters {
+		if !caps.Filters[f] {
+			return false
+		}
+	}
+	hwType, err := accelDeviceType(accel)
+	if err != nil {
+		return false
+	}
+	if hwType == C.AV_HWDEVICE_TYPE_NONE {
+		return true
+	}
+	return hwTypes[hwType] && C.lpms_probe_hwdevice(C.int(hwType)) >= 0
+}
+
+func (caps *CapabilityInfo) HasEncoder(name string) bool {
+	return containsSorted(caps.Encoders, name)
+}
+
+func (caps *CapabilityInfo) HasDecoder(name string) bool {
+	return containsSorted(caps.Decoders, name)
+}
+
+func (caps *CapabilityInfo) HasMuxer(name string) bool {
+	return containsSorted(caps.Muxers, name)
+}
+
+// Supports reports whether the codec can be encoded with the acceleration
+func (caps *CapabilityInfo) Supports(accel Acceleration, codec VideoCodec) bool {
+	for _, c := range caps.VideoCodecs[accel] {
+		if c == codec {
+			return true
+		}
+	}
+	return false
+}
+
+func containsSorted(list []string, name string) bool {
+	i := sort.SearchStrings(list, name)
+	return i < len(list) && list[i] == name
+}
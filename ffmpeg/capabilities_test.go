@@ -0,0 +1,21 @@
+package ffmpeg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCapabilities_Software(t *testing.T) {
+	caps := Capabilities()
+	assert.True(t, caps.HasEncoder("libx264"))
+	assert.True(t, caps.HasDecoder("h264"))
+	assert.True(t, caps.HasMuxer("mpegts"))
+	assert.False(t, caps.HasEncoder("nonexistent"))
+	assert.True(t, caps.Filters["scale"])
+	assert.True(t, caps.Filters["signature"])
+	assert.Contains(t, caps.Accelerations, Software)
+	assert.True(t, caps.Supports(Software, H264))
+	assert.NotContains(t, caps.Accelerations, Amd)
+	assert.False(t, caps.Supports(Amd, H264))
+}
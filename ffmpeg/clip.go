@@ -0,0 +1,87 @@
+package ffmpeg
+
+//#include "transcoder.h"
+import "C"
+
+import (
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// ClipWindow clips an output to a window of absolute input timestamps, or of
+// wall-clock time, instead of the From and To offsets from the first frame.
+// The window is applied to every Transcode call of a session on its own, so a
+// clip may span several segments: each call emits only the frames inside the
+// window and keeps their timestamps. A zero bound leaves that side open.
+// Bounds have millisecond precision.
+type ClipWindow struct {
+	// Input timestamps, after discontinuity adjustments
+	FromPTS, ToPTS time.Duration
+	// Wall-clock times, anchored at the first frame of each track of the
+	// segment by TranscodeOptionsIn.ProgramDateTime
+	FromTime, ToTime time.Time
+}
+
+func (w ClipWindow) IsZero() bool {
+	return w.FromPTS == 0 && w.ToPTS == 0 && w.FromTime.IsZero() && w.ToTime.IsZero()
+}
+
+func (w ClipWindow) isWallClock() bool {
+	return !w.FromTime.IsZero() || !w.ToTime.IsZero()
+}
+
+func validateClipWindow(input *TranscodeOptionsIn, p TranscodeOptions) error {
+	w := p.Clip
+	if p.From != 0 || p.To != 0 {
+		glog.Warning("Clip window can't be combined with 'From' and 'To'")
+		return ErrTranscoderClipConfig
+	}
+	if w.isWallClock() {
+		if w.FromPTS != 0 || w.ToPTS != 0 {
+			glog.Warning("Clip window is either in timestamps or in wall-clock time")
+			return ErrTranscoderClipConfig
+		}
+		if input.ProgramDateTime.IsZero() {
+			glog.Warning("Wall-clock clip window needs the input's program date time")
+			return ErrTranscoderClipConfig
+		}
+		if !w.FromTime.IsZero() && !w.ToTime.IsZero() && w.ToTime.Before(w.FromTime) {
+			glog.Warning("Clip window should end after it starts")
+			return ErrTranscoderClipConfig
+		}
+		return nil
+	}
+	if w.FromPTS < 0 || w.ToPTS < 0 || w.ToPTS > 0 && w.ToPTS < w.FromPTS {
+		glog.Warning("Clip window should end after it starts")
+		return ErrTranscoderClipConfig
+	}
+	return nil
+}
+
+// clipParams returns the clip bounds in milliseconds and how they are
+// measured, for an output of the given input, and whether the window is
+// already over
+func clipParams(input *TranscodeOptionsIn, p TranscodeOptions) (from, to int, mode C.int, closed bool) {
+	w := p.Clip
+	if w.IsZero() {
+		return int(p.From.Milliseconds()), int(p.To.Milliseconds()), C.LPMS_CLIP_RELATIVE, false
+	}
+	if !w.isWallClock() {
+		return int(w.FromPTS.Milliseconds()), int(w.ToPTS.Milliseconds()), C.LPMS_CLIP_ABSOLUTE, false
+	}
+	// the window started before this segment if negative, which leaves it open
+	if !w.FromTime.IsZero() {
+		if d := w.FromTime.Sub(input.ProgramDateTime); d > 0 {
+			from = int(d.Milliseconds())
+		}
+	}
+	if !w.ToTime.IsZero() {
+		to = int(w.ToTime.Sub(input.ProgramDateTime).Milliseconds())
+		if to <= 0 {
+			// the window ended before this segment; zero would leave it open
+			return 0, 0, C.LPMS_CLIP_ANCHORED, true
+		}
+	}
+	return from, to, C.LPMS_CLIP_ANCHORED, false
+}
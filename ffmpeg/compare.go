@@ -0,0 +1,407 @@
+package ffmpeg
+
+import (
+	"io/ioutil"
+	"unsafe"
+)
+
+// #include <stdlib.h>
+// #include "extras.h"
+import "C"
+
+// SignMatchLevel is the result of the FFmpeg signature lookup
+type SignMatchLevel int
+
+const (
+	SignMatchNone SignMatchLevel = iota
+	SignMatchPartial
+	SignMatchWhole
+)
+
+// CompareCheck identifies the check that made a comparison fail
+type CompareCheck int
+
+const (
+	CompareCheckNone CompareCheck = iota
+	CompareCheckDimensions
+	CompareCheckFrameCount
+	CompareCheckSignLookup
+	CompareCheckPacketCount
+	CompareCheckBitrate
+	CompareCheckAudio
+	CompareCheckScore
+)
+
+var CompareCheckName = map[CompareCheck]string{
+	CompareCheckNone:        "none",
+	CompareCheckDimensions:  "dimensions",
+	CompareCheckFrameCount:  "frameCount",
+	CompareCheckSignLookup:  "signLookup",
+	CompareCheckPacketCount: "packetCount",
+	CompareCheckBitrate:     "bitrate",
+	CompareCheckAudio:       "audio",
+	CompareCheckScore:       "score",
+}
+
+func (c CompareCheck) String() string {
+	return CompareCheckName[c]
+}
+
+type SignatureCompareThresholds struct {
+	// Lowest FFmpeg signature lookup result that is considered a match
+	MinMatchLevel SignMatchLevel
+	// Max L1 distance between two fine signatures (0-760) for frames to match
+	MaxFrameDistance int
+	// Max difference in signed frame counts; negative disables the check
+	MaxFrameCountDiff int
+	// Min fraction of matched frames; zero disables the check
+	MinScore float64
+	// Whether both signatures must be computed over the same dimensions
+	RequireSameDimensions bool
+}
+
+// Mirrors the behaviour of CompareSignatureByPath / CompareSignatureByBuffer
+var DefaultSignatureCompareThresholds = SignatureCompareThresholds{
+	MinMatchLevel:     SignMatchPartial,
+	MaxFrameDistance:  76,
+	MaxFrameCountDiff: -1,
+}
+
+type SignatureComparison struct {
+	Match           bool
+	Score           float64 // fraction of frames with a matching fine signature
+	MatchLevel      SignMatchLevel
+	MatchedFrames   int
+	UnmatchedFrames int
+	FrameCountDiff  int
+	WidthDiff       int
+	HeightDiff      int
+	FailedCheck     CompareCheck
+}
+
+type VideoCompareThresholds struct {
+	// Max unmatched audio packets, and max difference in audio packet counts
+	MaxAudioMismatch int
+	// Max difference in total packet counts; negative disables the check
+	MaxPacketCountDiff int
+	// Max relative difference in video bitrate; negative disables the check
+	MaxBitrateDiff float64
+	// Min fraction of matched audio packets; zero disables the check
+	MinScore float64
+	// Whether both videos must have the same dimensions
+	RequireSameDimensions bool
+}
+
+// Mirrors the behaviour of CompareVideoByPath / CompareVideoByBuffer
+var DefaultVideoCompareThresholds = VideoCompareThresholds{
+	MaxAudioMismatch:      10,
+	MaxPacketCountDiff:    -1,
+	MaxBitrateDiff:        -1,
+	RequireSameDimensions: true,
+}
+
+type VideoComparison struct {
+	Match                bool
+	Score                float64 // fraction of audio packets with a matching md5
+	MatchedPackets       int
+	UnmatchedPackets     int
+	PacketCountDiff      int
+	AudioPacketCountDiff int
+	WidthDiff            int
+	HeightDiff           int
+	BitrateDiff          int64
+	FailedCheck          CompareCheck
+}
+
+// compare two signature files and return detailed matching information.
+// Uses DefaultSignatureCompareThresholds if th is nil
+func CompareSignatureDetailsByPath(fname1 string, fname2 string, th *SignatureCompareThresholds) (*SignatureComparison, error) {
+	if len(fname1) <= 0 || len(fname2) <= 0 {
+		return nil, ErrSignCompare
+	}
+	data1, err := ioutil.ReadFile(fname1)
+	if err != nil {
+		return nil, err
+	}
+	data2, err := ioutil.ReadFile(fname2)
+	if err != nil {
+		return nil, err
+	}
+	return CompareSignatureDetailsByBuffer(data1, data2, th)
+}
+
+// compare two signature buffers and return detailed matching information.
+// Uses DefaultSignatureCompareThresholds if th is nil
+func CompareSignatureDetailsByBuffer(data1 []byte, data2 []byte, th *SignatureCompareThresholds) (*SignatureComparison, error) {
+	if len(data1) == 0 || len(data2) == 0 {
+		return nil, ErrEmptyData
+	}
+	if th == nil {
+		th = &DefaultSignatureCompareThresholds
+	}
+	pdata1 := unsafe.Pointer(&data1[0])
+	pdata2 := unsafe.Pointer(&data2[0])
+	level := int(C.lpms_compare_sign_bybuffer(pdata1, C.int(len(data1)), pdata2, C.int(len(data2))))
+	if level < 0 {
+		return nil, ErrSignCompare
+	}
+	sig1, err := parseSignature(data1)
+	if err != nil {
+		return nil, err
+	}
+	sig2, err := parseSignature(data2)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &SignatureComparison{
+		MatchLevel:     SignMatchLevel(level),
+		FrameCountDiff: sig2.frames - sig1.frames,
+		WidthDiff:      sig2.width - sig1.width,
+		HeightDiff:     sig2.height - sig1.height,
+	}
+	res.MatchedFrames = matchFineSignatures(sig1.fine, sig2.fine, th.MaxFrameDistance)
+	res.UnmatchedFrames = maxInt(len(sig1.fine), len(sig2.fine)) - res.MatchedFrames
+	if total := res.MatchedFrames + res.UnmatchedFrames; total > 0 {
+		res.Score = float64(res.MatchedFrames) / float64(total)
+	}
+
+	switch {
+	case th.RequireSameDimensions && (res.WidthDiff != 0 || res.HeightDiff != 0):
+		res.FailedCheck = CompareCheckDimensions
+	case th.MaxFrameCountDiff >= 0 && absInt(res.FrameCountDiff) > th.MaxFrameCountDiff:
+		res.FailedCheck = CompareCheckFrameCount
+	case res.MatchLevel < th.MinMatchLevel:
+		res.FailedCheck = CompareCheckSignLookup
+	case th.MinScore > 0 && res.Score < th.MinScore:
+		res.FailedCheck = CompareCheckScore
+	}
+	res.Match = res.FailedCheck == CompareCheckNone
+	return res, nil
+}
+
+// compare two video files and return detailed matching information.
+// Uses DefaultVideoCompareThresholds if th is nil
+func CompareVideoDetailsByPath(fname1 string, fname2 string, th *VideoCompareThresholds) (*VideoComparison, error) {
+	if len(fname1) <= 0 || len(fname2) <= 0 {
+		return nil, ErrVideoCompare
+	}
+	cfpath1 := C.CString(fname1)
+	defer C.free(unsafe.Pointer(cfpath1))
+	cfpath2 := C.CString(fname2)
+	defer C.free(unsafe.Pointer(cfpath2))
+
+	var stats C.video_match_stats
+	if C.lpms_compare_video_stats_bypath(cfpath1, cfpath2, &stats) < 0 {
+		return nil, ErrVideoCompare
+	}
+	return compareVideoStats(&stats, th), nil
+}
+
+// compare two video buffers and return detailed matching information.
+// Uses DefaultVideoCompareThresholds if th is nil
+func CompareVideoDetailsByBuffer(data1 []byte, data2 []byte, th *VideoCompareThresholds) (*VideoComparison, error) {
+	if len(data1) == 0 || len(data2) == 0 {
+		return nil, ErrEmptyData
+	}
+	pdata1 := unsafe.Pointer(&data1[0])
+	pdata2 := unsafe.Pointer(&data2[0])
+
+	var stats C.video_match_stats
+	if C.lpms_compare_video_stats_bybuffer(pdata1, C.int(len(data1)), pdata2, C.int(len(data2)), &stats) < 0 {
+		return nil, ErrVideoCompare
+	}
+	return compareVideoStats(&stats, th), nil
+}
+
+func compareVideoStats(stats *C.video_match_stats, th *VideoCompareThresholds) *VideoComparison {
+	if th == nil {
+		th = &DefaultVideoCompareThresholds
+	}
+	apackets1, apackets2 := int(stats.apackets1), int(stats.apackets2)
+	bitrate1, bitrate2 := int64(stats.bitrate1), int64(stats.bitrate2)
+	res := &VideoComparison{
+		MatchedPackets:       int(stats.amatched),
+		UnmatchedPackets:     minInt(apackets1, apackets2) - int(stats.amatched),
+		PacketCountDiff:      int(stats.packets2 - stats.packets1),
+		AudioPacketCountDiff: apackets2 - apackets1,
+		WidthDiff:            int(stats.width2 - stats.width1),
+		HeightDiff:           int(stats.height2 - stats.height1),
+		BitrateDiff:          bitrate2 - bitrate1,
+		Score:                1.0,
+	}
+	if total := maxInt(apackets1, apackets2); total > 0 {
+		res.Score = float64(res.MatchedPackets) / float64(total)
+	}
+	var bitrateDiff float64
+	if maxBitrate := maxInt64(bitrate1, bitrate2); maxBitrate > 0 {
+		bitrateDiff = float64(absInt64(res.BitrateDiff)) / float64(maxBitrate)
+	}
+
+	switch {
+	case th.RequireSameDimensions && (res.WidthDiff != 0 || res.HeightDiff != 0):
+		res.FailedCheck = CompareCheckDimensions
+	case th.MaxPacketCountDiff >= 0 && absInt(res.PacketCountDiff) > th.MaxPacketCountDiff:
+		res.FailedCheck = CompareCheckPacketCount
+	case th.MaxBitrateDiff >= 0 && bitrateDiff > th.MaxBitrateDiff:
+		res.FailedCheck = CompareCheckBitrate
+	case absInt(res.AudioPacketCountDiff) > th.MaxAudioMismatch ||
+		res.UnmatchedPackets >= th.MaxAudioMismatch:
+		res.FailedCheck = CompareCheckAudio
+	case th.MinScore > 0 && res.Score < th.MinScore:
+		res.FailedCheck = CompareCheckScore
+	}
+	res.Match = res.FailedCheck == CompareCheckNone
+	return res
+}
+
+// Number of elements in a MPEG-7 fine (frame) signature
+const fineSignatureSize = 380
+
+// Signed frames are paired within this many positions of each other
+const maxFineSignatureScan = 300
+
+type signatureInfo struct {
+	width, height int
+	frames        int
+	fine          [][]byte // ternary elements of each frame signature
+}
+
+// parseSignature reads the MPEG-7 binary signature format
+// written by the FFmpeg signature filter
+func parseSignature(data []byte) (*signatureInfo, error) {
+	r := &bitReader{data: data}
+	info := &signatureInfo{}
+	r.skip(32 + 1 + 32) // NumOfSpatialRegions, SpatialLocationFlag, PixelX/Y,1
+	info.width = int(r.read(16)) + 1
+	info.height = int(r.read(16)) + 1
+	r.skip(32) // StartFrameOfSpatialRegion
+	info.frames = int(r.read(32))
+	r.skip(16 + 1 + 32 + 32) // MediaTimeUnit, MediaTimeFlag, Start/EndMediaTime
+	segments := int(r.read(32))
+	if r.overflow || segments < 0 {
+		return nil, ErrSignCompare
+	}
+	// coarse signatures: frame and time bounds plus 5 x 243 bits of words
+	r.skip(segments * (32 + 32 + 1 + 32 + 32 + 5*243))
+	r.skip(1) // CompressionFlag
+	if r.overflow {
+		return nil, ErrSignCompare
+	}
+	const fineBits = 1 + 32 + 8 + 5*8 + fineSignatureSize/5*8
+	for r.remaining() >= fineBits {
+		r.skip(1 + 32 + 8 + 5*8) // MediaTimeFlag, MediaTime, FrameConfidence, Words
+		elems := make([]byte, 0, fineSignatureSize)
+		for i := 0; i < fineSignatureSize/5; i++ {
+			// five ternary elements are packed into every byte
+			v := byte(r.read(8))
+			for j := 0; j < 5; j++ {
+				elems = append(elems, v%3)
+				v /= 3
+			}
+		}
+		info.fine = append(info.fine, elems)
+	}
+	return info, nil
+}
+
+// matchFineSignatures counts frame signatures of the shorter list that have a
+// counterpart in the other one within the maximum L1 distance
+func matchFineSignatures(fine1, fine2 [][]byte, maxDist int) int {
+	first, second := fine1, fine2
+	if len(first) > len(second) {
+		first, second = second, first
+	}
+	scan := minInt(len(second)-len(first), maxFineSignatureScan) + 1
+	matched := 0
+	for i, sig := range first {
+		start, end := maxInt(0, i-scan), minInt(len(second), i+scan+1)
+		for j := start; j < end; j++ {
+			if fineSignatureDistance(sig, second[j]) <= maxDist {
+				matched++
+				break
+			}
+		}
+	}
+	return matched
+}
+
+func fineSignatureDistance(a, b []byte) int {
+	dist := 0
+	for i := range a {
+		if a[i] > b[i] {
+			dist += int(a[i] - b[i])
+		} else {
+			dist += int(b[i] - a[i])
+		}
+	}
+	return dist
+}
+
+type bitReader struct {
+	data     []byte
+	pos      int // in bits
+	overflow bool
+}
+
+func (r *bitReader) remaining() int {
+	return len(r.data)*8 - r.pos
+}
+
+func (r *bitReader) skip(n int) {
+	if n > r.remaining() {
+		r.pos = len(r.data) * 8
+		r.overflow = true
+		return
+	}
+	r.pos += n
+}
+
+func (r *bitReader) read(n int) uint32 {
+	if n > r.remaining() {
+		r.skip(n)
+		return 0
+	}
+	var v uint32
+	for i := 0; i < n; i++ {
+		bit := (r.data[r.pos/8] >> (7 - uint(r.pos%8))) & 1
+		v = v<<1 | uint32(bit)
+		r.pos++
+	}
+	return v
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func absInt(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
+
+func maxInt64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func absInt64(a int64) int64 {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
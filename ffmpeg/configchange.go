@@ -0,0 +1,77 @@
+package ffmpeg
+
+import "strings"
+
+// ConfigChange is the set of input configuration changes between two segments
+type ConfigChange int
+
+const (
+	ConfigChangeVideoCodec ConfigChange = 1 << iota
+	ConfigChangeResolution
+	ConfigChangePixelFormat
+	ConfigChangeSampleRate
+	ConfigChangeChannelLayout
+	ConfigChangeAudioCodec
+)
+
+var ConfigChangeName = map[ConfigChange]string{
+	ConfigChangeVideoCodec:    "VideoCodec",
+	ConfigChangeResolution:    "Resolution",
+	ConfigChangePixelFormat:   "PixelFormat",
+	ConfigChangeSampleRate:    "SampleRate",
+	ConfigChangeChannelLayout: "ChannelLayout",
+	ConfigChangeAudioCodec:    "AudioCodec",
+}
+
+// Changes that require rebuilding the decoder, filters and encoders.
+// Audio codec changes are handled by reopening the demuxer instead.
+const significantConfigChanges = ConfigChangeVideoCodec | ConfigChangeResolution |
+	ConfigChangePixelFormat | ConfigChangeSampleRate | ConfigChangeChannelLayout
+
+// Significant is true if the change requires rebuilding the session
+func (c ConfigChange) Significant() bool {
+	return c&significantConfigChanges != 0
+}
+
+func (c ConfigChange) String() string {
+	if c == 0 {
+		return "None"
+	}
+	var names []string
+	for f := ConfigChangeVideoCodec; f <= ConfigChangeAudioCodec; f <<= 1 {
+		if c&f != 0 {
+			names = append(names, ConfigChangeName[f])
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// classifyConfigChange compares the formats of two consecutive segments.
+// Audio parameters are only compared if both segments have audio.
+func classifyConfigChange(prev, cur MediaFormatInfo) ConfigChange {
+	var c ConfigChange
+	if prev.Vcodec == "" || cur.Vcodec == "" {
+		return c
+	}
+	if prev.Vcodec != cur.Vcodec {
+		c |= ConfigChangeVideoCodec
+	}
+	if prev.Width != cur.Width || prev.Height != cur.Height {
+		c |= ConfigChangeResolution
+	}
+	if prev.PixFormat != cur.PixFormat {
+		c |= ConfigChangePixelFormat
+	}
+	if prev.Acodec != "" && cur.Acodec != "" {
+		if prev.Acodec != cur.Acodec {
+			c |= ConfigChangeAudioCodec
+		}
+		if prev.SampleRate != cur.SampleRate {
+			c |= ConfigChangeSampleRate
+		}
+		if prev.Channels != cur.Channels || prev.ChannelLayout != cur.ChannelLayout {
+			c |= ConfigChangeChannelLayout
+		}
+	}
+	return c
+}
@@ -0,0 +1,46 @@
+package ffmpeg
+
+//#include "transcoder.h"
+import "C"
+
+import "time"
+
+// ErrorTolerance sets how corrupt input is handled. It applies per segment,
+// including to the decoders a session reuses.
+type ErrorTolerance int
+
+const (
+	// FFmpeg's defaults: undecodable packets fail the transcode, damaged
+	// frames are encoded as decoded
+	ErrorToleranceDefault ErrorTolerance = C.LPMS_ERROR_TOLERANCE_DEFAULT
+	// Fail with ErrTranscoderInputCorrupt on any corruption found
+	ErrorToleranceStrict ErrorTolerance = C.LPMS_ERROR_TOLERANCE_STRICT
+	// Decode past errors, concealing the damage
+	ErrorToleranceConceal ErrorTolerance = C.LPMS_ERROR_TOLERANCE_CONCEAL
+	// Drop corrupt video frames along with the rest of their GOP
+	ErrorToleranceSkipGOP ErrorTolerance = C.LPMS_ERROR_TOLERANCE_SKIP_GOP
+)
+
+// Corruption is the damage found in the input, as a signal of its quality
+type Corruption struct {
+	// Packets flagged corrupt by the demuxer or failing to decode
+	Packets int
+	// Frames the decoder flagged as damaged
+	Frames int
+	// Frames left out by ErrorToleranceSkipGOP
+	Dropped int
+	// Input timestamps of the first 64 corruptions
+	PTS []time.Duration
+}
+
+func corruption(r *C.output_results) Corruption {
+	c := Corruption{
+		Packets: int(r.corrupt_packets),
+		Frames:  int(r.corrupt_frames),
+		Dropped: int(r.dropped_frames),
+	}
+	for i := 0; i < int(r.nb_corruptions) && i < C.MAX_CORRUPTIONS; i++ {
+		c.PTS = append(c.PTS, time.Duration(r.corruptions[i])*time.Microsecond)
+	}
+	return c
+}
@@ -0,0 +1,80 @@
+package ffmpeg
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTranscoder_ErrorTolerance(t *testing.T) {
+	run, dir := setupTest(t)
+	defer os.RemoveAll(dir)
+
+	cmd := `
+    ffmpeg -loglevel warning -f lavfi -i testsrc=size=320x240:rate=30 -t 4 -c:v libx264 -g 30 -f mpegts in.ts
+    # blank out a stretch in the middle of the segment
+    cp in.ts corrupt.ts
+    size=$(stat -c %s in.ts)
+    dd if=/dev/zero of=corrupt.ts bs=188 seek=$((size / 188 / 2)) count=20 conv=notrunc
+  `
+	run(cmd)
+
+	transcode := func(fname string, tolerance ErrorTolerance) (*TranscodeResults, error) {
+		tc := NewTranscoder()
+		defer tc.StopTranscoder()
+		in := &TranscodeOptionsIn{Fname: dir + "/" + fname, ErrorTolerance: tolerance}
+		return tc.Transcode(in, []TranscodeOptions{{Oname: dir + "/out.ts", Profile: P144p30fps16x9}})
+	}
+
+	// clean input passes whatever the tolerance
+	res, err := transcode("in.ts", ErrorToleranceStrict)
+	require.NoError(t, err)
+	assert.Equal(t, Corruption{}, res.Corruption)
+	clean := res.Decoded.Frames
+
+	_, err = transcode("corrupt.ts", ErrorToleranceStrict)
+	assert.Equal(t, ErrTranscoderInputCorrupt, err)
+
+	res, err = transcode("corrupt.ts", ErrorToleranceConceal)
+	require.NoError(t, err)
+	assert.NotZero(t, res.Corruption.Packets+res.Corruption.Frames)
+	assert.NotEmpty(t, res.Corruption.PTS)
+	assert.Zero(t, res.Corruption.Dropped)
+
+	res, err = transcode("corrupt.ts", ErrorToleranceSkipGOP)
+	require.NoError(t, err)
+	assert.NotZero(t, res.Corruption.Dropped)
+	assert.NotEmpty(t, res.Corruption.PTS)
+	assert.True(t, res.Decoded.Frames < clean, "frames of the corrupt GOP dropped")
+}
+
+func TestTranscoder_ErrorToleranceSession(t *testing.T) {
+	run, dir := setupTest(t)
+	defer os.RemoveAll(dir)
+
+	cmd := `
+    ffmpeg -loglevel warning -f lavfi -i testsrc=size=320x240:rate=30 -t 4 -c:v libx264 -g 30 -f mpegts in.ts
+    cp in.ts corrupt.ts
+    size=$(stat -c %s in.ts)
+    dd if=/dev/zero of=corrupt.ts bs=188 seek=$((size / 188 / 2)) count=20 conv=notrunc
+  `
+	run(cmd)
+
+	// the decoders are reused, so each segment's tolerance has to replace
+	// the previous one's
+	tc := NewTranscoder()
+	defer tc.StopTranscoder()
+	transcode := func(fname string, tolerance ErrorTolerance) (*TranscodeResults, error) {
+		in := &TranscodeOptionsIn{Fname: dir + "/" + fname, ErrorTolerance: tolerance}
+		return tc.Transcode(in, []TranscodeOptions{{Oname: dir + "/out.ts", Profile: P144p30fps16x9}})
+	}
+	_, err := transcode("in.ts", ErrorToleranceStrict)
+	require.NoError(t, err)
+	res, err := transcode("corrupt.ts", ErrorToleranceConceal)
+	require.NoError(t, err, "no longer strict")
+	assert.NotZero(t, res.Corruption.Packets+res.Corruption.Frames)
+	_, err = transcode("corrupt.ts", ErrorToleranceStrict)
+	assert.Equal(t, ErrTranscoderInputCorrupt, err)
+}
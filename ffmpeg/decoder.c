@@ -3,6 +3,8 @@
 #include "logging.h"
 
 #include <libavutil/pixfmt.h>
+#include <libavutil/imgutils.h>
+#include <libavutil/hwcontext.h>
 
 static int lpms_send_packet(struct input_ctx *ictx, AVCodecContext *dec, AVPacket *pkt)
 {
@@ -18,6 +20,12 @@ static int lpms_receive_frame(struct input_ctx *ictx, AVCodecContext *dec, AVFra
     if (!ret && frame && !is_flush_frame(frame)) {
       ictx->pkt_diff--; // decrease buffer count for non-sentinel video frames
       if (ictx->flushing) ictx->sentinel_count = 0;
+      ret = drop_corrupt_frame(ictx, frame);
+      if (ret > 0) {
+        av_frame_unref(frame);
+        return AVERROR(EAGAIN);
+      }
+      if (!ret) ret = check_frame_limits(ictx, frame);
     }
     return ret;
 }
@@ -36,9 +44,230 @@ packet_cleanup:
   return ret;
 }
 
+static void record_corruption(struct input_ctx *ictx, int64_t ts, AVRational tb)
+{
+  if (AV_NOPTS_VALUE == ts || ictx->nb_corruptions >= MAX_CORRUPTIONS) return;
+  ts = av_rescale_q(ts, tb, AV_TIME_BASE_Q);
+  // a corrupt packet often decodes into a corrupt frame
+  if (ictx->nb_corruptions && ictx->corruptions[ictx->nb_corruptions - 1] == ts) return;
+  ictx->corruptions[ictx->nb_corruptions++] = ts;
+}
+
+static int check_packet_corruption(struct input_ctx *ictx, AVPacket *pkt)
+{
+  int ret = 0;
+  if (!(pkt->flags & AV_PKT_FLAG_CORRUPT)) return 0;
+  ictx->corrupt_packets++;
+  record_corruption(ictx, pkt->pts, ictx->ic->streams[pkt->stream_index]->time_base);
+  if (LPMS_ERROR_TOLERANCE_STRICT == ictx->error_tolerance) {
+    ret = lpms_ERR_INPUT_CORRUPT;
+    LPMS_ERR_RETURN("Corrupt input packet");
+  }
+  if (LPMS_ERROR_TOLERANCE_SKIP_GOP == ictx->error_tolerance && pkt->stream_index == ictx->vi) {
+    ictx->skip_gop = 1;
+  }
+  return 0;
+}
+
+// Returns 0 to carry on without a frame when the error tolerance allows for
+// the decoding error err, otherwise the error to fail with
+int tolerate_decode_error(struct input_ctx *ictx, AVPacket *pkt, int err)
+{
+  if (AVERROR(EAGAIN) == err || AVERROR_EOF == err || AVERROR(ENOMEM) == err ||
+      lpms_ERR_INPUT_LIMITS == err || lpms_ERR_INPUT_CORRUPT == err) return err;
+  if (LPMS_ERROR_TOLERANCE_STRICT == ictx->error_tolerance && AVERROR_INVALIDDATA == err) {
+    ictx->corrupt_packets++;
+    if (pkt) record_corruption(ictx, pkt->pts, ictx->ic->streams[pkt->stream_index]->time_base);
+    return lpms_ERR_INPUT_CORRUPT;
+  }
+  if (LPMS_ERROR_TOLERANCE_CONCEAL != ictx->error_tolerance &&
+      LPMS_ERROR_TOLERANCE_SKIP_GOP != ictx->error_tolerance) return err;
+  ictx->corrupt_packets++;
+  if (pkt) {
+    record_corruption(ictx, pkt->pts, ictx->ic->streams[pkt->stream_index]->time_base);
+    if (LPMS_ERROR_TOLERANCE_SKIP_GOP == ictx->error_tolerance && pkt->stream_index == ictx->vi) {
+      ictx->skip_gop = 1;
+    }
+  }
+  LPMS_WARN("Decoding error tolerated");
+  return 0;
+}
+
+// Counts corrupt video frames and returns 1 for those to drop, or
+// lpms_ERR_INPUT_CORRUPT when not tolerated
+int drop_corrupt_frame(struct input_ctx *ictx, AVFrame *frame)
+{
+  int ret = 0;
+  int corrupt = (frame->flags & AV_FRAME_FLAG_CORRUPT) || frame->decode_error_flags;
+  if (corrupt) {
+    ictx->corrupt_frames++;
+    record_corruption(ictx, frame->best_effort_timestamp, ictx->ic->streams[ictx->vi]->time_base);
+    if (LPMS_ERROR_TOLERANCE_STRICT == ictx->error_tolerance) {
+      ret = lpms_ERR_INPUT_CORRUPT;
+      LPMS_ERR_RETURN("Corrupt input frame");
+    }
+  }
+  if (LPMS_ERROR_TOLERANCE_SKIP_GOP != ictx->error_tolerance) return 0;
+  if (corrupt) ictx->skip_gop = 1;
+  else if (frame->key_frame) ictx->skip_gop = 0;
+  if (ictx->skip_gop) ictx->dropped_frames++;
+  return ictx->skip_gop;
+}
+
+static void set_error_tolerance(AVCodecContext *c, enum lpms_error_tolerance tolerance)
+{
+  // decoders are reused across segments, so clear what an earlier mode set
+  c->err_recognition &= ~AV_EF_EXPLODE;
+  c->error_concealment = FF_EC_GUESS_MVS | FF_EC_DEBLOCK;
+  c->flags &= ~AV_CODEC_FLAG_OUTPUT_CORRUPT;
+  switch (tolerance) {
+    case LPMS_ERROR_TOLERANCE_STRICT:
+      c->err_recognition |= AV_EF_EXPLODE;
+      break;
+    case LPMS_ERROR_TOLERANCE_CONCEAL:
+      c->error_concealment = FF_EC_GUESS_MVS | FF_EC_DEBLOCK;
+      c->flags |= AV_CODEC_FLAG_OUTPUT_CORRUPT;
+      break;
+    case LPMS_ERROR_TOLERANCE_SKIP_GOP:
+      // have corrupt frames flagged rather than held back, to drop them
+      c->flags |= AV_CODEC_FLAG_OUTPUT_CORRUPT;
+      break;
+    default:
+      break;
+  }
+}
+
+static int check_packet_limits(struct input_ctx *ictx, AVPacket *pkt)
+{
+  input_limits *l = &ictx->limits;
+  int ret = 0;
+  if (l->max_packet_size && pkt->size > l->max_packet_size) {
+    ret = lpms_ERR_INPUT_LIMITS;
+    LPMS_ERR_RETURN("Input packet too large");
+  }
+  int64_t ts = pkt->dts != AV_NOPTS_VALUE ? pkt->dts : pkt->pts;
+  if (!l->max_pts_gap || pkt->stream_index >= MAX_OUTPUT_SIZE || ts == AV_NOPTS_VALUE) return 0;
+  ts = av_rescale_q(ts, ictx->ic->streams[pkt->stream_index]->time_base, AV_TIME_BASE_Q);
+  int64_t last = ictx->last_pkt_ts[pkt->stream_index];
+  ictx->last_pkt_ts[pkt->stream_index] = ts;
+  if (last != AV_NOPTS_VALUE && FFABS(ts - last) > (int64_t) l->max_pts_gap * 1000) {
+    ret = lpms_ERR_INPUT_LIMITS;
+    LPMS_ERR_RETURN("Input timestamp gap too large");
+  }
+  return 0;
+}
+
+int check_frame_limits(struct input_ctx *ictx, AVFrame *frame)
+{
+  input_limits *l = &ictx->limits;
+  int ret = 0;
+  int w = frame->width, h = frame->height;
+  if (l->max_width && l->max_height &&
+      !(w <= l->max_width && h <= l->max_height) &&
+      !(h <= l->max_width && w <= l->max_height)) {
+    ret = lpms_ERR_INPUT_LIMITS;
+    LPMS_ERR_RETURN("Input resolution too large");
+  }
+  ictx->nb_frames++;
+  if (l->max_frames && ictx->nb_frames > l->max_frames) {
+    ret = lpms_ERR_INPUT_LIMITS;
+    LPMS_ERR_RETURN("Too many input frames");
+  }
+  if (l->max_duration && frame->best_effort_timestamp != AV_NOPTS_VALUE) {
+    int64_t ts = av_rescale_q(frame->best_effort_timestamp,
+      ictx->ic->streams[ictx->vi]->time_base, AV_TIME_BASE_Q);
+    if (AV_NOPTS_VALUE == ictx->first_frame_ts) ictx->first_frame_ts = ts;
+    if (ts - ictx->first_frame_ts > (int64_t) l->max_duration * 1000) {
+      ret = lpms_ERR_INPUT_LIMITS;
+      LPMS_ERR_RETURN("Input too long");
+    }
+  }
+  if (l->max_frame_memory && ictx->vc) {
+    // a frame for each reference, reordering slot and frame thread,
+    // plus the one being decoded
+    enum AVPixelFormat fmt = frame->format;
+    if (frame->hw_frames_ctx) fmt = ((AVHWFramesContext*)frame->hw_frames_ctx->data)->sw_format;
+    int size = av_image_get_buffer_size(fmt, w, h, 1);
+    int held = 1 + FFMAX(ictx->vc->refs, 1) + ictx->vc->has_b_frames;
+    if (ictx->vc->active_thread_type & FF_THREAD_FRAME) held += ictx->vc->thread_count;
+    if (size > 0 && (int64_t) size * held > l->max_frame_memory) {
+      ret = lpms_ERR_INPUT_LIMITS;
+      LPMS_ERR_RETURN("Decoded frames need too much memory");
+    }
+  }
+  return 0;
+}
+
+// Works out the output timestamp offset from the first timestamp of each
+// segment, and keeps track of where the session ends
+static void update_ts_offset(struct input_ctx *ictx, AVPacket *pkt)
+{
+  if (AV_NOPTS_VALUE == pkt->pts) return;
+  AVRational tb = ictx->ic->streams[pkt->stream_index]->time_base;
+  int64_t ts = av_rescale_q(pkt->pts, tb, AV_TIME_BASE_Q);
+  if (!ictx->ts_segment_started) {
+    switch (ictx->ts_mode) {
+      case LPMS_TS_REBASE:
+        ictx->ts_offset = -ts;
+        break;
+      case LPMS_TS_CONTINUOUS:
+        ictx->ts_offset = (ictx->ts_session_started ? ictx->ts_end : 0) - ts;
+        break;
+      default:
+        ictx->ts_offset = 0;
+        break;
+    }
+    ictx->ts_segment_started = ictx->ts_session_started = 1;
+  }
+  int64_t end = ts + ictx->ts_offset + av_rescale_q(pkt->duration, tb, AV_TIME_BASE_Q);
+  if (end > ictx->ts_end) ictx->ts_end = end;
+}
+
+// Checks the first packet of each stream in a segment against where the
+// stream ended in the previous one. A jump back, or ahead by more than the
+// threshold, is flagged as a discontinuity just like a signalled one, for
+// transmuxing to close up with dts_diff.
+static void detect_discontinuity(struct input_ctx *ictx, AVPacket *pkt)
+{
+  int s = pkt->stream_index;
+  if (s >= MAX_OUTPUT_SIZE || AV_NOPTS_VALUE == pkt->dts) return;
+  AVRational tb = ictx->ic->streams[s]->time_base;
+  int64_t dts = av_rescale_q(pkt->dts, tb, AV_TIME_BASE_Q);
+  if (!ictx->discontinuity_checked[s]) {
+    ictx->discontinuity_checked[s] = 1;
+    int signalled = ictx->discontinuity[s];
+    // only transmuxing uses the flag; otherwise it just stands in for
+    // detection on this segment
+    if (!ictx->transmuxing) ictx->discontinuity[s] = 0;
+    int64_t gap = dts - ictx->next_in_dts[s];
+    if (ictx->discontinuity_threshold && !signalled &&
+        AV_NOPTS_VALUE != ictx->last_in_dts[s] &&
+        (dts <= ictx->last_in_dts[s] || gap > (int64_t) ictx->discontinuity_threshold * 1000)) {
+      if (ictx->transmuxing) ictx->discontinuity[s] = 1;
+      discontinuity_info *d = &ictx->discontinuities[ictx->nb_discontinuities++];
+      d->stream_index = s;
+      d->pts = AV_NOPTS_VALUE == pkt->pts ? dts : av_rescale_q(pkt->pts, tb, AV_TIME_BASE_Q);
+      d->gap = gap;
+      LPMS_INFO("Input timestamp discontinuity detected");
+    }
+  }
+  ictx->last_in_dts[s] = dts;
+  ictx->next_in_dts[s] = dts + av_rescale_q(pkt->duration, tb, AV_TIME_BASE_Q);
+}
+
 int demux_in(struct input_ctx *ictx, AVPacket *pkt)
 {
-  return av_read_frame(ictx->ic, pkt);
+  int ret = av_read_frame(ictx->ic, pkt);
+  if (ret < 0) return ret;
+  ret = check_packet_limits(ictx, pkt);
+  if (!ret) ret = check_packet_corruption(ictx, pkt);
+  if (ret < 0) {
+    av_packet_unref(pkt);
+    return ret;
+  }
+  detect_discontinuity(ictx, pkt);
+  update_ts_offset(ictx, pkt);
+  return ret;
 }
 
 int decode_in(struct input_ctx *ictx, AVPacket *pkt, AVFrame *frame, int *stream_index)
@@ -74,10 +303,15 @@ int decode_in(struct input_ctx *ictx, AVPacket *pkt, AVFrame *frame, int *stream
   }
 
   ret = lpms_send_packet(ictx, decoder, pkt);
+  if (ret < 0) ret = tolerate_decode_error(ictx, pkt, ret);
   if (ret < 0) {
     LPMS_ERR_RETURN("Error sending packet to decoder");
   }
   ret = lpms_receive_frame(ictx, decoder, frame);
+  if (ret < 0 && ret != AVERROR(EAGAIN) && !tolerate_decode_error(ictx, pkt, ret)) {
+    // nothing decoded, but carry on
+    return lpms_ERR_PACKET_ONLY;
+  }
   if (ret == AVERROR(EAGAIN)) {
     // This is not really an error. It may be that packet just fed into
     // the decoder may be not enough to complete decoding. Upper level will
@@ -111,6 +345,10 @@ int flush_in(struct input_ctx *ictx, AVFrame *frame, int *stream_index)
     }
     ret = lpms_receive_frame(ictx, ictx->vc, frame);
     *stream_index = ictx->vi;
+    if (lpms_ERR_INPUT_LIMITS == ret || lpms_ERR_INPUT_CORRUPT == ret) {
+      ictx->flushed = 1;
+      return ret;
+    }
     // Keep flushing if we haven't received all frames back but stop after SENTINEL_MAX tries.
     if (ictx->pkt_diff != 0 && ictx->sentinel_count <= SENTINEL_MAX && (!ret || ret == AVERROR(EAGAIN))) {
       return ret;
@@ -224,6 +462,7 @@ int open_audio_decoder(input_params *params, struct input_ctx *ctx)
     ctx->ac = ac;
     ret = avcodec_parameters_to_context(ac, ic->streams[ctx->ai]->codecpar);
     if (ret < 0) LPMS_ERR(open_audio_err, "Unable to assign audio params");
+    set_error_tolerance(ac, params->error_tolerance);
     ret = avcodec_open2(ac, codec, NULL);
     if (ret < 0) LPMS_ERR(open_audio_err, "Unable to open audio decoder");
   }
@@ -317,6 +556,7 @@ int open_video_decoder(input_params *params, struct input_ctx *ctx)
     ctx->hw_type = params->hw_type;
     vc->pkt_timebase = ic->streams[ctx->vi]->time_base;
     av_opt_set(vc->priv_data, "xcoder-params", ctx->xcoderParams, 0);
+    set_error_tolerance(vc, params->error_tolerance);
     ret = avcodec_open2(vc, codec, opts);
     if (ret < 0) LPMS_ERR(open_decoder_err, "Unable to open video decoder");
   }
@@ -329,6 +569,13 @@ open_decoder_err:
   return ret;
 }
 
+void update_error_tolerance(struct input_ctx *ctx)
+{
+  // decoders check these flags as they decode, so no need to reopen them
+  if (ctx->vc) set_error_tolerance(ctx->vc, ctx->error_tolerance);
+  if (ctx->ac) set_error_tolerance(ctx->ac, ctx->error_tolerance);
+}
+
 int open_input(input_params *params, struct input_ctx *ctx)
 {
   AVFormatContext *ic   = NULL;
@@ -52,6 +52,37 @@ struct input_ctx {
   // In HW transcoding, demuxer is opened once and used,
   // so it is necessary to check whether the input pixel format does not change in the middle.
   enum AVPixelFormat last_format;
+
+  // Input limits and what they're checked against, for the current segment
+  input_limits limits;
+  int nb_frames;
+  int64_t first_frame_ts; // AV_TIME_BASE
+  int64_t last_pkt_ts[MAX_OUTPUT_SIZE]; // AV_TIME_BASE
+
+  // Corrupt input handling, and what was found in the current segment
+  enum lpms_error_tolerance error_tolerance;
+  int skip_gop;
+  int corrupt_packets, corrupt_frames, dropped_frames;
+  int64_t corruptions[MAX_CORRUPTIONS]; // AV_TIME_BASE
+  int nb_corruptions;
+
+  // Output timestamps: the offset added to input timestamps and the end of
+  // the session so far on the output timeline, in AV_TIME_BASE
+  enum lpms_ts_mode ts_mode;
+  int ts_segment_started, ts_session_started;
+  int64_t ts_offset, ts_end;
+
+  // Discontinuity detection: where each stream's input dts last was and
+  // where it should carry on, in AV_TIME_BASE, and whether the current
+  // segment has been checked yet
+  int discontinuity_threshold; // ms
+  int64_t last_in_dts[MAX_OUTPUT_SIZE], next_in_dts[MAX_OUTPUT_SIZE];
+  int discontinuity_checked[MAX_OUTPUT_SIZE];
+  discontinuity_info discontinuities[MAX_OUTPUT_SIZE];
+  int nb_discontinuities;
+
+  // Video frames of the stream before this input
+  int64_t frame_offset;
 };
 
 // Exported methods
@@ -59,6 +90,10 @@ int demux_in(struct input_ctx *ictx, AVPacket *pkt);
 int decode_in(struct input_ctx *ictx, AVPacket *pkt, AVFrame *frame, int *stream_index);
 int flush_in(struct input_ctx *ictx, AVFrame *frame, int *stream_index);
 int process_in(struct input_ctx *ictx, AVFrame *frame, AVPacket *pkt, int *stream_index);
+int check_frame_limits(struct input_ctx *ictx, AVFrame *frame);
+int tolerate_decode_error(struct input_ctx *ictx, AVPacket *pkt, int err);
+int drop_corrupt_frame(struct input_ctx *ictx, AVFrame *frame);
+void update_error_tolerance(struct input_ctx *ctx);
 enum AVPixelFormat hw2pixfmt(AVCodecContext *ctx);
 int open_input(input_params *params, struct input_ctx *ctx);
 int open_video_decoder(input_params *params, struct input_ctx *ctx);
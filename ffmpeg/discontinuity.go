@@ -0,0 +1,45 @@
+package ffmpeg
+
+//#include "transcoder.h"
+import "C"
+
+import "time"
+
+// Forward jump between segments of a session above which a discontinuity is
+// assumed, if unset
+const DefaultDiscontinuityThreshold = time.Second
+
+// Discontinuity is a jump in the input timestamps between the previous segment
+// of the session and this one, detected without Transcoder.Discontinuity being
+// called. Transmuxing closes it up just like a signalled one.
+type Discontinuity struct {
+	// Input stream that jumped
+	Stream int
+	// Input timestamp of the first packet of the stream in the segment
+	PTS time.Duration
+	// Distance from where the stream ended in the previous segment; negative
+	// for jumps back
+	Gap time.Duration
+}
+
+// discontinuityMillis maps TranscodeOptionsIn.DiscontinuityThreshold to the
+// threshold for the C side, where zero disables detection
+func discontinuityMillis(threshold time.Duration) int64 {
+	if threshold == 0 {
+		threshold = DefaultDiscontinuityThreshold
+	}
+	return limitMillis(threshold)
+}
+
+func discontinuities(r *C.output_results) []Discontinuity {
+	var ds []Discontinuity
+	for i := 0; i < int(r.nb_discontinuities) && i < C.MAX_OUTPUT_SIZE; i++ {
+		d := r.discontinuities[i]
+		ds = append(ds, Discontinuity{
+			Stream: int(d.stream_index),
+			PTS:    time.Duration(d.pts) * time.Microsecond,
+			Gap:    time.Duration(d.gap) * time.Microsecond,
+		})
+	}
+	return ds
+}
@@ -0,0 +1,74 @@
+package ffmpeg
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiscontinuityMillis(t *testing.T) {
+	assert.Equal(t, int64(1000), discontinuityMillis(0))
+	assert.Equal(t, int64(0), discontinuityMillis(-1))
+	assert.Equal(t, int64(250), discontinuityMillis(250*time.Millisecond))
+}
+
+func TestTranscoder_DetectDiscontinuity(t *testing.T) {
+	run, dir := setupTest(t)
+	defer os.RemoveAll(dir)
+
+	cmd := `
+    ffmpeg -loglevel warning -f lavfi -i testsrc=size=320x240:rate=30 -f lavfi -i sine -t 2 -c:v libx264 -c:a aac -f mpegts seg0.ts
+    ffmpeg -loglevel warning -i seg0.ts -c copy -output_ts_offset 2 -muxdelay 0 seg1.ts
+    # the source restarted, then jumped ahead
+    cp seg0.ts seg2.ts
+    ffmpeg -loglevel warning -i seg0.ts -c copy -output_ts_offset 100 -muxdelay 0 seg3.ts
+    ffmpeg -loglevel warning -i seg0.ts -c copy -output_ts_offset 200 -muxdelay 0 seg4.ts
+  `
+	run(cmd)
+
+	for _, transmux := range []bool{false, true} {
+		tc := NewTranscoder()
+		transcode := func(i int, threshold time.Duration) []Discontinuity {
+			in := &TranscodeOptionsIn{
+				Fname:                  fmt.Sprintf("%s/seg%d.ts", dir, i),
+				Transmuxing:            transmux,
+				DiscontinuityThreshold: threshold,
+			}
+			out := []TranscodeOptions{{Oname: fmt.Sprintf("%s/out-%v-%d.ts", dir, transmux, i), Profile: P144p30fps16x9}}
+			if transmux {
+				out[0].Profile = VideoProfile{Format: FormatNone}
+				out[0].VideoEncoder = ComponentOptions{Name: "copy"}
+				out[0].AudioEncoder = ComponentOptions{Name: "copy"}
+			}
+			res, err := tc.Transcode(in, out)
+			require.NoError(t, err)
+			return res.Discontinuities
+		}
+		assert.Empty(t, transcode(0, 0))
+		assert.Empty(t, transcode(1, 0), "segments carry on")
+
+		ds := transcode(2, 0)
+		require.Len(t, ds, 2, "both streams jump back")
+		for _, d := range ds {
+			assert.True(t, d.Gap < -time.Second, d.Gap)
+		}
+
+		ds = transcode(3, 0)
+		require.Len(t, ds, 2, "both streams jump ahead")
+		for _, d := range ds {
+			assert.True(t, d.Gap > 90*time.Second, d.Gap)
+			assert.True(t, d.PTS > 100*time.Second, d.PTS)
+		}
+
+		// below the threshold, disabled, or signalled
+		assert.Empty(t, transcode(4, 200*time.Second))
+		assert.Empty(t, transcode(0, -1))
+		tc.Discontinuity()
+		assert.Empty(t, transcode(3, 0))
+		tc.StopTranscoder()
+	}
+}
@@ -383,6 +383,11 @@ encode_cleanup:
 int mux(AVPacket *pkt, AVRational tb, struct output_ctx *octx, AVStream *ost)
 {
   pkt->stream_index = ost->index;
+  if (octx->ts_offset && *octx->ts_offset) {
+    int64_t offset = av_rescale_q(*octx->ts_offset, AV_TIME_BASE_Q, tb);
+    if (pkt->pts != AV_NOPTS_VALUE) pkt->pts += offset;
+    if (pkt->dts != AV_NOPTS_VALUE) pkt->dts += offset;
+  }
   if (av_cmp_q(tb, ost->time_base)) {
     av_packet_rescale_ts(pkt, tb, ost->time_base);
   }
@@ -412,6 +417,13 @@ int mux(AVPacket *pkt, AVRational tb, struct output_ctx *octx, AVStream *ost)
       }
       octx->last_audio_dts = pkt->dts;
   }
+  if (AVMEDIA_TYPE_VIDEO == ost->codecpar->codec_type &&
+      (pkt->flags & AV_PKT_FLAG_KEY) && pkt->pts != AV_NOPTS_VALUE) {
+      if (octx->res->nb_keyframes < MAX_KEYFRAMES) {
+        octx->res->keyframes[octx->res->nb_keyframes] = av_rescale_q(pkt->pts, ost->time_base, AV_TIME_BASE_Q);
+      }
+      octx->res->nb_keyframes++;
+  }
   if (AVMEDIA_TYPE_VIDEO == ost->codecpar->codec_type) {
       //after a long time of transcoding on GPU, exactly 6.5 hours, sometimes here,
       //got weird packets which DTS > PTS. But muxer doesn't agree with those packets.
@@ -491,9 +503,13 @@ int process_out(struct input_ctx *ictx, struct output_ctx *octx, AVCodecContext
 
   int is_video = (AVMEDIA_TYPE_VIDEO == ost->codecpar->codec_type);
   int is_audio = (AVMEDIA_TYPE_AUDIO == ost->codecpar->codec_type);
+  if (is_video && inf && inf->key_frame && LPMS_KF_ALIGN_SOURCE == octx->kf_align) {
+    octx->kf_source_pts = av_rescale_q(inf->pts, ictx->ic->streams[ictx->vi]->time_base, AV_TIME_BASE_Q);
+  }
   ret = filtergraph_write(inf, ictx, octx, filter, is_video);
   if (ret < 0) goto proc_cleanup;
 
+  int nb_filtered = 0;
   while (1) {
     // Drain the filter. Each input frame may have multiple output frames
     AVFrame *frame = filter->frame;
@@ -506,6 +522,20 @@ int process_out(struct input_ctx *ictx, struct output_ctx *octx, AVCodecContext
       frame = NULL;
     } else if (ret < 0) goto proc_cleanup;
 
+    // the fps filter fills timestamp gaps with duplicates
+    if (is_video && inf && frame && ictx->limits.max_dup_frames &&
+        ++nb_filtered > ictx->limits.max_dup_frames) {
+      av_frame_unref(frame);
+      ret = lpms_ERR_INPUT_LIMITS;
+      LPMS_ERR(proc_cleanup, "Too many frames duplicated by the filters");
+    }
+
+    // time of the frame on the input timeline, before any clip rebasing
+    int64_t frame_time = AV_NOPTS_VALUE;
+    if (is_video && frame && octx->kf_align) {
+      frame_time = av_rescale_q(frame->pts, av_buffersink_get_time_base(filter->sink_ctx), AV_TIME_BASE_Q);
+    }
+
     if (is_video && !octx->clip_start_pts_found && frame) {
       octx->clip_start_pts = frame->pts;
       octx->clip_start_pts_found = 1;
@@ -515,7 +545,7 @@ int process_out(struct input_ctx *ictx, struct output_ctx *octx, AVCodecContext
       octx->clip_audio_start_pts_found = 1;
     }
 
-
+    if (octx->clip_closed && frame) goto skip;
     if (is_video && octx->clip_to && octx->clip_start_pts_found && frame && frame->pts > octx->clip_to_pts + octx->clip_start_pts) goto skip;
     if (is_audio && octx->clip_to && octx->clip_audio_start_pts_found && frame && frame->pts > octx->clip_audio_to_pts + octx->clip_audio_start_pts) {
       goto skip;
@@ -531,7 +561,7 @@ int process_out(struct input_ctx *ictx, struct output_ctx *octx, AVCodecContext
             octx->next_kf_pts = frame->pts + octx->gop_pts_len;
           }
         }
-        if (octx->clip_from && frame) {
+        if (octx->clip_from && LPMS_CLIP_RELATIVE == octx->clip_mode && frame) {
           frame->pts -= octx->clip_from_pts + octx->clip_start_pts;
         }
       }
@@ -542,7 +572,7 @@ int process_out(struct input_ctx *ictx, struct output_ctx *octx, AVCodecContext
     if (is_audio && octx->clip_from && frame && frame->pts < octx->clip_audio_from_pts + octx->clip_audio_start_pts) {
       goto skip;
     }
-    if (is_audio && octx->clip_from && frame) {
+    if (is_audio && octx->clip_from && LPMS_CLIP_RELATIVE == octx->clip_mode && frame) {
       frame->pts -= octx->clip_audio_from_pts + octx->clip_audio_start_pts;
     }
 
@@ -552,6 +582,20 @@ int process_out(struct input_ctx *ictx, struct output_ctx *octx, AVCodecContext
         octx->next_kf_pts = frame->pts + octx->gop_pts_len;
     }
 
+    // Align keyframes with the other outputs
+    if (LPMS_KF_ALIGN_TIME == octx->kf_align && frame_time != AV_NOPTS_VALUE) {
+      int64_t slot = frame_time / octx->kf_interval;
+      if (frame_time < 0 && frame_time % octx->kf_interval) slot--;
+      if (slot != octx->kf_slot) {
+        frame->pict_type = AV_PICTURE_TYPE_I;
+        octx->kf_slot = slot;
+      }
+    } else if (LPMS_KF_ALIGN_SOURCE == octx->kf_align && frame_time != AV_NOPTS_VALUE &&
+               octx->kf_source_pts != AV_NOPTS_VALUE && frame_time >= octx->kf_source_pts) {
+      frame->pict_type = AV_PICTURE_TYPE_I;
+      octx->kf_source_pts = AV_NOPTS_VALUE;
+    }
+
     if(octx->is_dnn_profile) {
       ret = getmetadatainf(frame, octx);
       if(ret == -1 && frame == NULL) {
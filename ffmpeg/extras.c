@@ -2,11 +2,17 @@
 #include <libavformat/avformat.h>
 #include <libavfilter/avfilter.h>
 #include <stdbool.h>
+#include <libavfilter/buffersrc.h>
+#include <libavfilter/buffersink.h>
 #include <libavutil/md5.h>
+#include <libavutil/hwcontext.h>
+#include <libavutil/opt.h>
+#include <libavutil/pixdesc.h>
 #include "extras.h"
 #include "logging.h"
 
 #define MAX_AMISMATCH 10
+#define MAX_AMATCH_SCAN 300
 #define INC_MD5_COUNT 300
 #define MAX_MD5_COUNT 30000
 #define MD5_SIZE 16   //sizeof(int)*4 byte
@@ -156,6 +162,9 @@ int lpms_get_codec_info(char *fname, pcodec_info out)
   ret = avformat_find_stream_info(ic, NULL);
   if (ret < 0) { ret = GET_CODEC_INTERNAL_ERROR; goto close_format_context; }
 
+  out->duration = ic->duration;
+  out->start_time = ic->start_time;
+  out->bitrate = ic->bit_rate;
   vstream = av_find_best_stream(ic, AVMEDIA_TYPE_VIDEO, -1, -1, &vc, 0);
   astream = av_find_best_stream(ic, AVMEDIA_TYPE_AUDIO, -1, -1, &ac, 0);
   bool audio_present = astream >= 0;
@@ -176,12 +185,21 @@ int lpms_get_codec_info(char *fname, pcodec_info out)
       }
       out->width  = ic->streams[vstream]->codecpar->width;
       out->height = ic->streams[vstream]->codecpar->height;
+      out->video_profile = ic->streams[vstream]->codecpar->profile;
+      out->video_level = FFMAX(ic->streams[vstream]->codecpar->level, 0);
+      AVRational fps = ic->streams[vstream]->avg_frame_rate;
+      if (!fps.num || !fps.den) fps = ic->streams[vstream]->r_frame_rate;
+      out->fps_num = fps.num;
+      out->fps_den = fps.den;
   } else {
       // Indicate failure to extract video codec from given container
       out->video_codec[0] = 0;
   }
   if (audio_present && ac->name) {
       strncpy(out->audio_codec, ac->name, MIN(strlen(out->audio_codec), strlen(ac->name))+1);
+      out->sample_rate    = ic->streams[astream]->codecpar->sample_rate;
+      out->channels       = ic->streams[astream]->codecpar->channels;
+      out->channel_layout = ic->streams[astream]->codecpar->channel_layout;
   } else {
       // Indicate failure to extract audio codec from given container
       out->audio_codec[0] = 0;
@@ -379,18 +397,18 @@ clean:
   avformat_close_input(&ifmt_ctx);
   return ret;
 }
-// check validity for audio md5 data
-bool is_valid_md5data(struct match_info* info1, struct match_info *info2)
+// count audio packets of the shorter stream whose md5 is found in the other
+// stream, searching within a window sized by the packet count difference
+static int count_md5_matches(struct match_info* info1, struct match_info *info2)
 {
 #define max(a, b) ((a) > (b) ? (a) : (b))
 #define min(a, b) ((a) < (b) ? (a) : (b))
   struct match_info* first = info1->apacketcount < info2->apacketcount? info1: info2;
   struct match_info* second = info1->apacketcount >= info2->apacketcount? info1: info2;
   int packetdiff = second->apacketcount - first->apacketcount;
-  if (packetdiff > MAX_AMISMATCH) return false;
   int matchingcount = 0;
   for (int i = 0; i < first->apacketcount; i++) {
-    int scanscope = packetdiff + 1;
+    int scanscope = min(packetdiff, MAX_AMATCH_SCAN) + 1;
     int *psrcmpd = first->pmd5array + (i*4);
     int nstart = max(0,i-scanscope);
     int nend = min(second->apacketcount,i+scanscope);
@@ -401,9 +419,57 @@ bool is_valid_md5data(struct match_info* info1, struct match_info *info2)
       }
     }
   }
-  int realdiff = first->apacketcount - matchingcount;
+  return matchingcount;
+#undef max
+#undef min
+}
+
+// check validity for audio md5 data
+bool is_valid_md5data(struct match_info* info1, struct match_info *info2)
+{
+  int first = FFMIN(info1->apacketcount, info2->apacketcount);
+  int packetdiff = FFABS(info1->apacketcount - info2->apacketcount);
+  if (packetdiff > MAX_AMISMATCH) return false;
+  int realdiff = first - count_md5_matches(info1, info2);
   return realdiff < MAX_AMISMATCH ? true: false;
 }
+
+// collect matching statistics of two video buffers.
+// @param buffer1         the pointer of the first video buffer.
+// @param buffer2         the pointer of the second video buffer.
+// @param len1            the length of the first video buffer.
+// @param len2            the length of the second video buffer.
+// @param out             statistics of both buffers, filled on success.
+// @return  <0: error =0: success
+int lpms_compare_video_stats_bybuffer(void *buffer1, int len1, void *buffer2, int len2, pvideo_match_stats out)
+{
+  int ret = 0;
+  struct match_info info1 = {0,}, info2 = {0,};
+
+  ret = get_matchinfo(buffer1,len1,&info1);
+  if(ret < 0) goto clean;
+
+  ret = get_matchinfo(buffer2,len2,&info2);
+  if(ret < 0) goto clean;
+
+  out->width1 = info1.width;
+  out->height1 = info1.height;
+  out->width2 = info2.width;
+  out->height2 = info2.height;
+  out->bitrate1 = info1.bit_rate;
+  out->bitrate2 = info2.bit_rate;
+  out->packets1 = info1.packetcount;
+  out->packets2 = info2.packetcount;
+  out->apackets1 = info1.apacketcount;
+  out->apackets2 = info2.apacketcount;
+  out->amatched = count_md5_matches(&info1, &info2);
+clean:
+  if(info1.pmd5array) av_free(info1.pmd5array);
+  if(info2.pmd5array) av_free(info2.pmd5array);
+
+  return ret;
+}
+
 // compare two video buffers whether those matches or not.
 // @param buffer1         the pointer of the first video buffer.
 // @param buffer2         the pointer of the second video buffer.
@@ -456,3 +522,446 @@ int lpms_compare_video_bypath(char *vpath1, char *vpath2)
 
   return ret;
 }
+
+// collect matching statistics of two video files.
+// @param vpath1        full path of the first video file.
+// @param vpath2        full path of the second video file.
+// @param out           statistics of both files, filled on success.
+// @return  <0: error =0: success
+int lpms_compare_video_stats_bypath(char *vpath1, char *vpath2, pvideo_match_stats out)
+{
+  int ret = 0;
+  int len1, len2;
+  uint8_t *buffer1, *buffer2;
+  buffer1 = get_filebuffer(vpath1, &len1);
+  if(buffer1 == NULL) return AVERROR(ENOMEM);
+  buffer2 = get_filebuffer(vpath2, &len2);
+  if(buffer2 == NULL) {
+      av_freep(&buffer1);
+      return AVERROR(ENOMEM);
+  }
+  ret = lpms_compare_video_stats_bybuffer(buffer1, len1, buffer2, len2, out);
+
+  av_freep(&buffer1);
+  av_freep(&buffer2);
+
+  return ret;
+}
+
+// generate a signature of the video track of the input file.
+// frames are decoded and passed through the given signature filter, which
+// writes the signature file when the filtergraph is freed.
+// @param fname         full path of the input video file.
+// @param sfilters      signature filter description, including the output file name.
+// @return  <0: error =0: success
+int lpms_generate_signature(char *fname, char *sfilters)
+{
+  int ret = 0, vi = -1;
+  char args[512];
+  AVFormatContext *ic = NULL;
+  AVCodecContext *vc = NULL;
+  AVCodec *codec = NULL;
+  AVFilterGraph *graph = NULL;
+  AVFilterContext *src_ctx = NULL, *sink_ctx = NULL;
+  AVFilterInOut *outputs = NULL, *inputs = NULL;
+  AVPacket *pkt = NULL;
+  AVFrame *frame = NULL, *sframe = NULL;
+
+  ret = avformat_open_input(&ic, fname, NULL, NULL);
+  if (ret < 0) LPMS_ERR(sign_cleanup, "signature: Unable to open input");
+  ret = avformat_find_stream_info(ic, NULL);
+  if (ret < 0) LPMS_ERR(sign_cleanup, "signature: Unable to find input info");
+  vi = av_find_best_stream(ic, AVMEDIA_TYPE_VIDEO, -1, -1, &codec, 0);
+  if (vi < 0) {
+    ret = vi;
+    LPMS_ERR(sign_cleanup, "signature: Unable to find video stream");
+  }
+
+  // open video decoder
+  vc = avcodec_alloc_context3(codec);
+  if (!vc) LPMS_ERR(sign_cleanup, "signature: Unable to alloc video decoder");
+  ret = avcodec_parameters_to_context(vc, ic->streams[vi]->codecpar);
+  if (ret < 0) LPMS_ERR(sign_cleanup, "signature: Unable to assign video params");
+  vc->pkt_timebase = ic->streams[vi]->time_base;
+  ret = avcodec_open2(vc, codec, NULL);
+  if (ret < 0) LPMS_ERR(sign_cleanup, "signature: Unable to open video decoder");
+
+  // set up buffer -> signature -> buffersink
+  graph = avfilter_graph_alloc();
+  outputs = avfilter_inout_alloc();
+  inputs = avfilter_inout_alloc();
+  pkt = av_packet_alloc();
+  frame = av_frame_alloc();
+  sframe = av_frame_alloc();
+  if (!graph || !outputs || !inputs || !pkt || !frame || !sframe) {
+    ret = AVERROR(ENOMEM);
+    LPMS_ERR(sign_cleanup, "signature: Unable to allocate");
+  }
+  snprintf(args, sizeof args,
+          "video_size=%dx%d:pix_fmt=%d:time_base=%d/%d:pixel_aspect=%d/%d",
+          vc->width, vc->height, vc->pix_fmt,
+          ic->streams[vi]->time_base.num, ic->streams[vi]->time_base.den,
+          vc->sample_aspect_ratio.num, FFMAX(vc->sample_aspect_ratio.den, 1));
+  ret = avfilter_graph_create_filter(&src_ctx, avfilter_get_by_name("buffer"),
+                                     "in", args, NULL, graph);
+  if (ret < 0) LPMS_ERR(sign_cleanup, "signature: Cannot create video buffer source");
+  ret = avfilter_graph_create_filter(&sink_ctx, avfilter_get_by_name("buffersink"),
+                                     "out", NULL, NULL, graph);
+  if (ret < 0) LPMS_ERR(sign_cleanup, "signature: Cannot create video buffer sink");
+  outputs->name       = av_strdup("in");
+  outputs->filter_ctx = src_ctx;
+  outputs->pad_idx    = 0;
+  outputs->next       = NULL;
+  inputs->name        = av_strdup("out");
+  inputs->filter_ctx  = sink_ctx;
+  inputs->pad_idx     = 0;
+  inputs->next        = NULL;
+  ret = avfilter_graph_parse_ptr(graph, sfilters, &inputs, &outputs, NULL);
+  if (ret < 0) LPMS_ERR(sign_cleanup, "signature: Unable to parse signature filters desc");
+  ret = avfilter_graph_config(graph, NULL);
+  if (ret < 0) LPMS_ERR(sign_cleanup, "signature: Unable configure signature filtergraph");
+
+  // decode all video frames and feed them into the filtergraph
+  int flushing = 0;
+  while (1) {
+    if (!flushing) {
+      ret = av_read_frame(ic, pkt);
+      if (ret == AVERROR_EOF) {
+        flushing = 1;
+        ret = avcodec_send_packet(vc, NULL);
+      } else if (ret < 0) {
+        LPMS_ERR(sign_cleanup, "signature: Unable to read input");
+      } else if (pkt->stream_index != vi) {
+        av_packet_unref(pkt);
+        continue;
+      } else {
+        ret = avcodec_send_packet(vc, pkt);
+        av_packet_unref(pkt);
+        // skip broken packets, like the signature filter would skip missing frames
+        if (ret == AVERROR_INVALIDDATA) continue;
+      }
+      if (ret < 0) LPMS_ERR(sign_cleanup, "signature: Error sending packet to decoder");
+    }
+    while (1) {
+      ret = avcodec_receive_frame(vc, frame);
+      if (ret == AVERROR(EAGAIN) || ret == AVERROR_EOF) break;
+      if (ret < 0) LPMS_ERR(sign_cleanup, "signature: Error receiving frame from decoder");
+      frame->pts = frame->best_effort_timestamp;
+      ret = av_buffersrc_add_frame(src_ctx, frame);
+      if (ret < 0) LPMS_ERR(sign_cleanup, "signature: Error feeding the filtergraph");
+      while (av_buffersink_get_frame(sink_ctx, sframe) >= 0) av_frame_unref(sframe);
+    }
+    if (flushing && ret == AVERROR_EOF) break;
+  }
+  // signal EOF to the signature filter and drain it
+  ret = av_buffersrc_close(src_ctx, AV_NOPTS_VALUE, AV_BUFFERSRC_FLAG_PUSH);
+  if (ret < 0) LPMS_ERR(sign_cleanup, "signature: Error closing the filtergraph");
+  while (av_buffersink_get_frame(sink_ctx, sframe) >= 0) av_frame_unref(sframe);
+  ret = 0;
+
+sign_cleanup:
+  avfilter_inout_free(&inputs);
+  avfilter_inout_free(&outputs);
+  // the signature file is written out when the filter is uninitialized
+  if (graph) avfilter_graph_free(&graph);
+  if (sframe) av_frame_free(&sframe);
+  if (frame) av_frame_free(&frame);
+  if (pkt) av_packet_free(&pkt);
+  if (vc) avcodec_free_context(&vc);
+  if (ic) avformat_close_input(&ic);
+  return ret;
+}
+
+static const AVCodec *next_codec(uintptr_t *it, int encoder)
+{
+  void *opaque = (void *)*it;
+  const AVCodec *codec;
+  while ((codec = av_codec_iterate(&opaque))) {
+    if (encoder ? av_codec_is_encoder(codec) : av_codec_is_decoder(codec)) break;
+  }
+  *it = (uintptr_t)opaque;
+  return codec;
+}
+
+const char *lpms_next_encoder(uintptr_t *it)
+{
+  const AVCodec *codec = next_codec(it, 1);
+  return codec ? codec->name : NULL;
+}
+
+const char *lpms_next_decoder(uintptr_t *it)
+{
+  const AVCodec *codec = next_codec(it, 0);
+  return codec ? codec->name : NULL;
+}
+
+const char *lpms_next_muxer(uintptr_t *it)
+{
+  void *opaque = (void *)*it;
+  const AVOutputFormat *fmt = av_muxer_iterate(&opaque);
+  *it = (uintptr_t)opaque;
+  return fmt ? fmt->name : NULL;
+}
+
+int lpms_has_filter(char *name)
+{
+  return avfilter_get_by_name(name) != NULL;
+}
+
+// check whether a device of the given type can actually be opened
+// @return  <0: error =0: success
+int lpms_probe_hwdevice(int hw_type)
+{
+  AVBufferRef *ref = NULL;
+  int ret = av_hwdevice_ctx_create(&ref, hw_type, NULL, NULL, 0);
+  av_buffer_unref(&ref);
+  return ret;
+}
+
+// demux the video packets of a file, storing the pts of keyframes in out.
+// @return  <0: error, otherwise the keyframe count, which may exceed max;
+//          the packet count goes in *frames if set
+static int scan_video(char *fname, int64_t *out, int max, int *frames)
+{
+  int ret = 0, vi = -1, count = 0, nb_packets = 0;
+  AVFormatContext *ic = NULL;
+  AVPacket *pkt = NULL;
+
+  ret = avformat_open_input(&ic, fname, NULL, NULL);
+  if (ret < 0) LPMS_ERR(keyframes_cleanup, "keyframes: Unable to open input");
+  ret = avformat_find_stream_info(ic, NULL);
+  if (ret < 0) LPMS_ERR(keyframes_cleanup, "keyframes: Unable to find input info");
+  vi = av_find_best_stream(ic, AVMEDIA_TYPE_VIDEO, -1, -1, NULL, 0);
+  if (vi < 0) {
+    ret = vi;
+    LPMS_ERR(keyframes_cleanup, "keyframes: Unable to find video stream");
+  }
+  pkt = av_packet_alloc();
+  if (!pkt) {
+    ret = AVERROR(ENOMEM);
+    LPMS_ERR(keyframes_cleanup, "keyframes: Unable to allocate packet");
+  }
+  // only demux; keep counting past max so the caller can size its buffer
+  while ((ret = av_read_frame(ic, pkt)) >= 0) {
+    if (pkt->stream_index == vi) nb_packets++;
+    if (pkt->stream_index == vi && (pkt->flags & AV_PKT_FLAG_KEY)) {
+      int64_t ts = pkt->pts != AV_NOPTS_VALUE ? pkt->pts : pkt->dts;
+      if (ts != AV_NOPTS_VALUE) {
+        if (count < max) {
+          out[count] = av_rescale_q(ts, ic->streams[vi]->time_base, AV_TIME_BASE_Q);
+        }
+        count++;
+      }
+    }
+    av_packet_unref(pkt);
+  }
+  ret = AVERROR_EOF == ret ? count : ret;
+  if (ret < 0) LPMS_ERR(keyframes_cleanup, "keyframes: Error reading input");
+  if (frames) *frames = nb_packets;
+
+keyframes_cleanup:
+  if (pkt) av_packet_free(&pkt);
+  if (ic) avformat_close_input(&ic);
+  return ret;
+}
+
+int lpms_video_keyframes(char *fname, int64_t *out, int max)
+{
+  return scan_video(fname, out, max, NULL);
+}
+
+int lpms_video_frames(char *fname)
+{
+  int frames = 0;
+  int ret = scan_video(fname, NULL, 0, &frames);
+  return ret < 0 ? ret : frames;
+}
+
+// generate a filler segment: the output of the video and audio source
+// filtergraphs, encoded like the source segments it stands in for. The codecs
+// are those of the named decoders, where there are encoders for them.
+// @param fname         output file name; always MPEG-TS, which the demuxer
+//                      of a session may be kept open for.
+// @param vcodec        decoder name of the source video.
+// @param pix_fmt       source pixel format, used if the encoder takes it.
+// @param vfilters      video filtergraph description, made of sources only.
+// @param acodec        decoder name of the source audio; NULL or empty for
+//                      no audio.
+// @param afilters      audio filtergraph description, made of sources only.
+// @param start         timestamp of the first frames, in AV_TIME_BASE units.
+// @return  <0: error =0: success
+int lpms_generate_filler(char *fname, char *vcodec, int pix_fmt, char *vfilters,
+                         char *acodec, char *afilters, int64_t start)
+{
+  int ret = 0;
+  char *codecs[2] = {vcodec, acodec};
+  char *descs[2] = {vfilters, afilters};
+  AVFilterGraph *graphs[2] = {NULL, NULL};
+  AVFilterContext *sinks[2] = {NULL, NULL};
+  AVCodecContext *encoders[2] = {NULL, NULL};
+  AVStream *streams[2] = {NULL, NULL};
+  int done[2] = {0, 0};
+  char *desc = NULL;
+  AVFormatContext *oc = NULL;
+  AVFilterInOut *inputs = NULL, *outputs = NULL;
+  AVPacket *pkt = av_packet_alloc();
+  AVFrame *frame = av_frame_alloc();
+  int nb_streams = acodec && *acodec ? 2 : 1;
+
+  if (!pkt || !frame) {
+    ret = AVERROR(ENOMEM);
+    LPMS_ERR(filler_cleanup, "filler: Unable to allocate");
+  }
+  ret = avformat_alloc_output_context2(&oc, NULL, "mpegts", fname);
+  if (ret < 0) LPMS_ERR(filler_cleanup, "filler: Unable to allocate muxer");
+
+  // index 0 is video, 1 audio
+  for (int i = 0; i < nb_streams; i++) {
+    int video = 0 == i;
+    AVCodec *dec = avcodec_find_decoder_by_name(codecs[i]);
+    AVCodec *codec = dec ? avcodec_find_encoder(dec->id) : NULL;
+    if (!codec) {
+      // leaves the session to rebuild for the other codec
+      LPMS_WARN("filler: No encoder for the source codec, using a default one");
+      codec = avcodec_find_encoder(video ? AV_CODEC_ID_MPEG4 : AV_CODEC_ID_AAC);
+    }
+    if (!codec) {
+      ret = AVERROR_ENCODER_NOT_FOUND;
+      LPMS_ERR(filler_cleanup, "filler: Unable to find encoder");
+    }
+
+    // end the graph in a format the encoder takes
+    const char *format = NULL;
+    if (video) {
+      enum AVPixelFormat fmt = codec->pix_fmts ? codec->pix_fmts[0] : AV_PIX_FMT_YUV420P;
+      for (int j = 0; codec->pix_fmts && codec->pix_fmts[j] != AV_PIX_FMT_NONE; j++) {
+        if (codec->pix_fmts[j] == pix_fmt) fmt = pix_fmt;
+      }
+      format = av_get_pix_fmt_name(fmt);
+    } else {
+      format = av_get_sample_fmt_name(codec->sample_fmts ? codec->sample_fmts[0] : AV_SAMPLE_FMT_FLTP);
+    }
+    size_t size = strlen(descs[i]) + strlen(format) + 32;
+    desc = av_malloc(size);
+    if (!desc) {
+      ret = AVERROR(ENOMEM);
+      LPMS_ERR(filler_cleanup, "filler: Unable to allocate filters desc");
+    }
+    snprintf(desc, size, "%s,%s=%s", descs[i], video ? "format=pix_fmts" : "aformat=sample_fmts", format);
+
+    graphs[i] = avfilter_graph_alloc();
+    if (!graphs[i]) {
+      ret = AVERROR(ENOMEM);
+      LPMS_ERR(filler_cleanup, "filler: Unable to allocate filtergraph");
+    }
+    ret = avfilter_graph_create_filter(&sinks[i],
+      avfilter_get_by_name(video ? "buffersink" : "abuffersink"), "out", NULL, NULL, graphs[i]);
+    if (ret < 0) LPMS_ERR(filler_cleanup, "filler: Cannot create buffer sink");
+    ret = avfilter_graph_parse2(graphs[i], desc, &inputs, &outputs);
+    if (ret < 0) LPMS_ERR(filler_cleanup, "filler: Unable to parse filters desc");
+    av_freep(&desc);
+    if (inputs || !outputs || outputs->next) {
+      ret = AVERROR(EINVAL);
+      LPMS_ERR(filler_cleanup, "filler: Filters need to be sources with one output");
+    }
+    ret = avfilter_link(outputs->filter_ctx, outputs->pad_idx, sinks[i], 0);
+    if (ret < 0) LPMS_ERR(filler_cleanup, "filler: Unable to link buffer sink");
+    avfilter_inout_free(&outputs);
+    ret = avfilter_graph_config(graphs[i], NULL);
+    if (ret < 0) LPMS_ERR(filler_cleanup, "filler: Unable configure filtergraph");
+
+    AVCodecContext *c = encoders[i] = avcodec_alloc_context3(codec);
+    if (!c) {
+      ret = AVERROR(ENOMEM);
+      LPMS_ERR(filler_cleanup, "filler: Unable to alloc encoder");
+    }
+    c->time_base = av_buffersink_get_time_base(sinks[i]);
+    if (video) {
+      c->width = av_buffersink_get_w(sinks[i]);
+      c->height = av_buffersink_get_h(sinks[i]);
+      c->pix_fmt = av_buffersink_get_format(sinks[i]);
+      c->sample_aspect_ratio = av_buffersink_get_sample_aspect_ratio(sinks[i]);
+      c->framerate = av_buffersink_get_frame_rate(sinks[i]);
+      // decode order is presentation order, so timestamps start at start
+      c->max_b_frames = 0;
+      // a still picture; speed matters more than size
+      av_opt_set(c->priv_data, "preset", "ultrafast", 0);
+    } else {
+      c->sample_fmt = av_buffersink_get_format(sinks[i]);
+      c->sample_rate = av_buffersink_get_sample_rate(sinks[i]);
+      c->channel_layout = av_buffersink_get_channel_layout(sinks[i]);
+      c->channels = av_buffersink_get_channels(sinks[i]);
+    }
+    if (oc->oformat->flags & AVFMT_GLOBALHEADER) c->flags |= AV_CODEC_FLAG_GLOBAL_HEADER;
+    ret = avcodec_open2(c, codec, NULL);
+    if (ret < 0) LPMS_ERR(filler_cleanup, "filler: Unable to open encoder");
+    if (!video && !(codec->capabilities & AV_CODEC_CAP_VARIABLE_FRAME_SIZE)) {
+      av_buffersink_set_frame_size(sinks[i], c->frame_size);
+    }
+    streams[i] = avformat_new_stream(oc, NULL);
+    if (!streams[i]) {
+      ret = AVERROR(ENOMEM);
+      LPMS_ERR(filler_cleanup, "filler: Unable to alloc stream");
+    }
+    streams[i]->time_base = c->time_base;
+    ret = avcodec_parameters_from_context(streams[i]->codecpar, c);
+    if (ret < 0) LPMS_ERR(filler_cleanup, "filler: Unable to set stream params");
+  }
+  // no audio to generate
+  for (int i = nb_streams; i < 2; i++) done[i] = 1;
+
+  if (!(oc->oformat->flags & AVFMT_NOFILE)) {
+    ret = avio_open(&oc->pb, fname, AVIO_FLAG_WRITE);
+    if (ret < 0) LPMS_ERR(filler_cleanup, "filler: Unable to open output file");
+  }
+  ret = avformat_write_header(oc, NULL);
+  if (ret < 0) LPMS_ERR(filler_cleanup, "filler: Unable to write header");
+
+  // take turns at the sources, a frame at a time, for the muxer to interleave
+  while (!done[0] || !done[1]) {
+    for (int i = 0; i < nb_streams; i++) {
+      if (done[i]) continue;
+      ret = av_buffersink_get_frame(sinks[i], frame);
+      if (ret == AVERROR_EOF) {
+        done[i] = 1;
+        ret = avcodec_send_frame(encoders[i], NULL);
+      } else if (ret < 0) {
+        LPMS_ERR(filler_cleanup, "filler: Unable to get filtered frame");
+      } else {
+        ret = avcodec_send_frame(encoders[i], frame);
+        av_frame_unref(frame);
+      }
+      if (ret < 0) LPMS_ERR(filler_cleanup, "filler: Error sending frame to encoder");
+      while (1) {
+        ret = avcodec_receive_packet(encoders[i], pkt);
+        if (ret == AVERROR(EAGAIN) || ret == AVERROR_EOF) break;
+        if (ret < 0) LPMS_ERR(filler_cleanup, "filler: Error receiving packet from encoder");
+        pkt->stream_index = streams[i]->index;
+        av_packet_rescale_ts(pkt, encoders[i]->time_base, streams[i]->time_base);
+        int64_t offset = av_rescale_q(start, AV_TIME_BASE_Q, streams[i]->time_base);
+        if (pkt->pts != AV_NOPTS_VALUE) pkt->pts += offset;
+        if (pkt->dts != AV_NOPTS_VALUE) pkt->dts += offset;
+        ret = av_interleaved_write_frame(oc, pkt);
+        if (ret < 0) LPMS_ERR(filler_cleanup, "filler: Error muxing packet");
+      }
+    }
+  }
+  ret = av_write_trailer(oc);
+  if (ret < 0) LPMS_ERR(filler_cleanup, "filler: Unable to write trailer");
+
+filler_cleanup:
+  av_freep(&desc);
+  avfilter_inout_free(&inputs);
+  avfilter_inout_free(&outputs);
+  for (int i = 0; i < 2; i++) {
+    if (graphs[i]) avfilter_graph_free(&graphs[i]);
+    if (encoders[i]) avcodec_free_context(&encoders[i]);
+  }
+  if (oc) {
+    if (!(oc->oformat->flags & AVFMT_NOFILE) && oc->pb) avio_closep(&oc->pb);
+    avformat_free_context(oc);
+  }
+  if (frame) av_frame_free(&frame);
+  if (pkt) av_packet_free(&pkt);
+  return ret;
+}
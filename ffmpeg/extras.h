@@ -1,19 +1,57 @@
 #ifndef _LPMS_EXTRAS_H_
 #define _LPMS_EXTRAS_H_
 
+#include <stdint.h>
+
 typedef struct s_codec_info {
   char * video_codec;
   char * audio_codec;
   int    pixel_format;
   int    width;
   int    height;
+  int    sample_rate;
+  int    channels;
+  uint64_t channel_layout;
+  int64_t duration;   // in AV_TIME_BASE units
+  int64_t start_time; // in AV_TIME_BASE units
+  int    video_profile;
+  int    video_level; // H.264 level_idc or HEVC general_level_idc, zero if unknown
+  int    fps_num, fps_den; // average video frame rate, zero if unknown
+  int64_t bitrate;    // bits per second of the whole input, zero if unknown
 } codec_info, *pcodec_info;
 
+typedef struct s_video_match_stats {
+  int     width1, height1;
+  int     width2, height2;
+  int64_t bitrate1, bitrate2;
+  int     packets1, packets2;   // total packet count
+  int     apackets1, apackets2; // audio packets with md5 computed
+  int     amatched;             // audio packets of the shorter stream found in the other
+} video_match_stats, *pvideo_match_stats;
+
 int lpms_rtmp2hls(char *listen, char *outf, char *ts_tmpl, char *seg_time, char *seg_start);
 int lpms_get_codec_info(char *fname, pcodec_info out);
 int lpms_compare_sign_bypath(char *signpath1, char *signpath2);
 int lpms_compare_sign_bybuffer(void *buffer1, int len1, void *buffer2, int len2);
 int lpms_compare_video_bypath(char *vpath1, char *vpath2);
 int lpms_compare_video_bybuffer(void *buffer1, int len1, void *buffer2, int len2);
+int lpms_compare_video_stats_bypath(char *vpath1, char *vpath2, pvideo_match_stats out);
+int lpms_compare_video_stats_bybuffer(void *buffer1, int len1, void *buffer2, int len2, pvideo_match_stats out);
+int lpms_generate_signature(char *fname, char *sfilters);
+// capability discovery; iterators return NULL once done
+const char *lpms_next_encoder(uintptr_t *it);
+const char *lpms_next_decoder(uintptr_t *it);
+const char *lpms_next_muxer(uintptr_t *it);
+int lpms_has_filter(char *name);
+int lpms_probe_hwdevice(int hw_type);
+// pts of video keyframes in AV_TIME_BASE units; returns the total count,
+// which may exceed max
+int lpms_video_keyframes(char *fname, int64_t *out, int max);
+// number of video packets, each a frame, without decoding; <0 on error
+int lpms_video_frames(char *fname);
+// video and silence from the source filtergraphs, encoded into MPEG-TS in the
+// codecs of the source, with the timestamps starting at start (AV_TIME_BASE units)
+int lpms_generate_filler(char *fname, char *vcodec, int pix_fmt, char *vfilters,
+                         char *acodec, char *afilters, int64_t start);
 
 #endif // _LPMS_EXTRAS_H_
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"path"
 	"path/filepath"
@@ -16,6 +17,7 @@ import (
 	"unsafe"
 
 	pb "github.com/eliteprox/lpms/ffmpeg/proto"
+	"github.com/eliteprox/lpms/metrics"
 	"github.com/golang/glog"
 	"github.com/golang/protobuf/proto"
 )
@@ -89,7 +91,13 @@ type Transcoder struct {
 	stopped    bool
 	started    bool
 	lastacodec string
-	mu         *sync.Mutex
+	lastFormat MediaFormatInfo
+	// what Passthrough profiles resolved to, kept for the session, and the
+	// highest segment bitrate of each of their outputs
+	passthrough      []passthroughChoice
+	passthroughPeaks []int
+	used             bool
+	mu               *sync.Mutex
 }
 
 type TranscodeOptionsIn struct {
@@ -97,6 +105,29 @@ type TranscodeOptionsIn struct {
 	Accel       Acceleration
 	Device      string
 	Transmuxing bool
+	// Wall-clock time of the first frame, such as the segment's
+	// EXT-X-PROGRAM-DATE-TIME. Anchors wall-clock clip windows.
+	ProgramDateTime time.Time
+	// Keyframe placement shared by all video outputs; KeyframeInterval is
+	// the spacing for KeyframeAlignTime
+	KeyframeAlign    KeyframeAlignment
+	KeyframeInterval time.Duration
+	// Passed to the LogHandler with the messages of this transcode;
+	// defaults to one naming the Transcoder
+	SessionTag string
+	// Guards against hostile input
+	Limits InputLimits
+	// Handling of corrupt input
+	ErrorTolerance ErrorTolerance
+	// Output timestamps; to be kept the same for all segments of a session
+	TimestampMode TimestampMode
+	// Forward jump in input timestamps between segments of a session above
+	// which a discontinuity is assumed; jumps back always are. Zero means
+	// DefaultDiscontinuityThreshold, negative disables detection.
+	DiscontinuityThreshold time.Duration
+
+	// Video frames of the stream before this input, for TranscodeVOD chunks
+	frameOffset int
 }
 
 type TranscodeOptions struct {
@@ -108,6 +139,8 @@ type TranscodeOptions struct {
 	CalcSign bool
 	From     time.Duration
 	To       time.Duration
+	// Clip window spanning segments; used instead of From and To
+	Clip ClipWindow
 
 	Muxer        ComponentOptions
 	VideoEncoder ComponentOptions
@@ -118,11 +151,24 @@ type MediaInfo struct {
 	Frames     int
 	Pixels     int64
 	DetectData DetectData
+	// What became of a Passthrough profile
+	Passthrough *PassthroughInfo
 }
 
 type TranscodeResults struct {
 	Decoded MediaInfo
 	Encoded []MediaInfo
+	// Input configuration changes since the previous segment
+	ConfigChange ConfigChange
+	// Whether the session was rebuilt because of a significant ConfigChange
+	Reinitialized bool
+	// Keyframes not lined up across the encoded video outputs, if
+	// TranscodeOptionsIn.KeyframeAlign is set
+	KeyframeMisalignments []KeyframeMisalignment
+	// Damage found in the input
+	Corruption Corruption
+	// Timestamp jumps from the previous segment, if not signalled
+	Discontinuities []Discontinuity
 }
 
 type PixelFormat struct {
@@ -246,6 +292,20 @@ type MediaFormatInfo struct {
 	Acodec, Vcodec string
 	PixFormat      PixelFormat
 	Width, Height  int
+	SampleRate     int
+	Channels       int
+	ChannelLayout  uint64
+	Duration       time.Duration
+	StartTime      time.Duration // zero if unknown
+	// H.264 profile of the video stream; ProfileNone if not H.264 or unknown
+	Profile Profile
+	// Level the video stream signals: H.264 level_idc or HEVC
+	// general_level_idc; zero if unknown
+	Level int
+	// Average frame rate of the video stream; zero if unknown
+	Framerate float64
+	// Bits per second of the whole input; zero if unknown
+	Bitrate int
 }
 
 func (f *MediaFormatInfo) ScaledHeight(width int) int {
@@ -278,9 +338,41 @@ func GetCodecInfo(fname string) (CodecStatus, MediaFormatInfo, error) {
 	format.PixFormat = PixelFormat{int(params_c.pixel_format)}
 	format.Width = int(params_c.width)
 	format.Height = int(params_c.height)
+	format.SampleRate = int(params_c.sample_rate)
+	format.Channels = int(params_c.channels)
+	format.ChannelLayout = uint64(params_c.channel_layout)
+	if params_c.duration > 0 {
+		// AV_TIME_BASE is in microseconds
+		format.Duration = time.Duration(params_c.duration) * time.Microsecond
+	}
+	if params_c.start_time != math.MinInt64 { // AV_NOPTS_VALUE
+		format.StartTime = time.Duration(params_c.start_time) * time.Microsecond
+	}
+	if format.Vcodec == "h264" {
+		format.Profile = h264ProfileFromCodec(int(params_c.video_profile))
+	}
+	format.Level = int(params_c.video_level)
+	if params_c.fps_num > 0 && params_c.fps_den > 0 {
+		format.Framerate = float64(params_c.fps_num) / float64(params_c.fps_den)
+	}
+	if params_c.bitrate > 0 {
+		format.Bitrate = int(params_c.bitrate)
+	}
 	return status, format, nil
 }
 
+func h264ProfileFromCodec(profile int) Profile {
+	switch profile {
+	case C.FF_PROFILE_H264_BASELINE, C.FF_PROFILE_H264_CONSTRAINED_BASELINE:
+		return ProfileH264Baseline
+	case C.FF_PROFILE_H264_MAIN:
+		return ProfileH264Main
+	case C.FF_PROFILE_H264_HIGH:
+		return ProfileH264High
+	}
+	return ProfileNone
+}
+
 // GetCodecInfo opens the segment and attempts to get video and audio codec names. Additionally, first return value
 // indicates whether the segment has zero video frames
 func GetCodecInfoBytes(data []byte) (CodecStatus, MediaFormatInfo, error) {
@@ -434,6 +526,21 @@ func Transcode(input string, workDir string, ps []VideoProfile) error {
 	return Transcode2(inopts, opts)
 }
 
+// mergeOpts returns the options in a with those in b added on top
+func mergeOpts(a, b map[string]string) map[string]string {
+	if len(b) <= 0 {
+		return a
+	}
+	opts := make(map[string]string, len(a)+len(b))
+	for k, v := range a {
+		opts[k] = v
+	}
+	for k, v := range b {
+		opts[k] = v
+	}
+	return opts
+}
+
 func newAVOpts(opts map[string]string) *C.AVDictionary {
 	var dict *C.AVDictionary
 	for key, value := range opts {
@@ -642,6 +749,10 @@ func createCOutputParams(input *TranscodeOptionsIn, ps []TranscodeOptions) ([]C.
 				return params, finalizer, err
 			}
 		}
+		profileOpts, err := EncoderOptsMap(param)
+		if err != nil {
+			return params, finalizer, err
+		}
 		encoder, scale_filter := p.VideoEncoder.Name, "scale"
 		if encoder == "" {
 			encoder, scale_filter, err = configEncoder(input, p)
@@ -670,10 +781,23 @@ func createCOutputParams(input *TranscodeOptionsIn, ps []TranscodeOptions) ([]C.
 		// when going from high fps to low fps (much more common when transcoding
 		// than going from low fps to high fps)
 		var fps C.AVRational
-		if param.Framerate > 0 {
+		// a capped frame rate keeps the source timestamps, like passthrough
+		fpsCapped := param.Framerate > 0 && param.FramerateMode == FramerateMax
+		if fpsCapped {
+			// Keep at most one frame per output frame interval, going by the
+			// frame's own timestamp, so lower rate and VFR sources are left
+			// alone. Frames on the interval grid sit mid-slot, away from
+			// rounding errors; neq rather than gt survives jumps back in time.
+			slot := fmt.Sprintf("%d/%d", param.Framerate, param.FramerateDen)
+			filters += fmt.Sprintf(",select='isnan(prev_selected_t)+neq(floor(t*%s+0.5)\\,floor(prev_selected_t*%s+0.5))'", slot, slot)
+		} else if param.Framerate > 0 {
 			filters += fmt.Sprintf(",fps=%d/%d", param.Framerate, param.FramerateDen)
 			fps = C.AVRational{num: C.int(param.Framerate), den: C.int(param.FramerateDen)}
 		}
+		if param.Profile == ProfileH265Main10 && p.Accel == Software && p.VideoEncoder.Name == "" {
+			// libx265 only encodes Main10 from 10-bit frames
+			filters += ",format=yuv420p10le"
+		}
 		// if has a detector profile, ignore all video options
 		if p.Detector != nil {
 			switch p.Detector.Type() {
@@ -691,42 +815,69 @@ func createCOutputParams(input *TranscodeOptionsIn, ps []TranscodeOptions) ([]C.
 		// NETINT encoder, and make sure we change relevant things here
 		// Any other options for the encoder can also be added here
 		xcoderOutParamsStr := ""
+		// Encoder options in layers, each overriding the ones before: defaults
+		// derived from the profile, the profile's EncoderOpts, the caller's
+		// VideoEncoder.Opts, then what timestamps and keyframes rely on
+		var derivedOpts map[string]string
+		forcedOpts := map[string]string{}
 		if len(p.VideoEncoder.Name) <= 0 && len(p.VideoEncoder.Opts) <= 0 {
-			p.VideoEncoder.Opts = map[string]string{
-				"forced-idr": "1",
+			derivedOpts = map[string]string{}
+			forcedOpts["forced-idr"] = "1"
+			if err := ValidateCodecProfile(p.Profile); err != nil {
+				glog.Warning(err)
+				return params, finalizer, ErrTranscoderPrf
 			}
 			switch p.Profile.Profile {
 			case ProfileH264Baseline, ProfileH264ConstrainedHigh:
 				if p.Accel != Netint {
-					p.VideoEncoder.Opts["profile"] = ProfileParameters[p.Profile.Profile]
-					p.VideoEncoder.Opts["bf"] = "0"
+					derivedOpts["profile"] = ProfileParameters[p.Profile.Profile]
+					derivedOpts["bf"] = "0"
 				} else {
 					xcoderOutParamsStr = "profile=high:gopPresetIdx=2"
 				}
 			case ProfileH264Main, ProfileH264High:
 				if p.Accel != Netint {
-					p.VideoEncoder.Opts["profile"] = ProfileParameters[p.Profile.Profile]
-					p.VideoEncoder.Opts["bf"] = "3"
+					derivedOpts["profile"] = ProfileParameters[p.Profile.Profile]
+					derivedOpts["bf"] = "3"
 				} else {
 					xcoderOutParamsStr = "profile=high"
 				}
+			case ProfileH265Main, ProfileH265Main10:
+				if p.Accel != Netint {
+					derivedOpts["profile"] = ProfileParameters[p.Profile.Profile]
+				} else if p.Profile.Profile == ProfileH265Main10 {
+					glog.Warning("HEVC Main10 isn't supported on Netint")
+					return params, finalizer, ErrTranscoderPrf
+				}
+				if p.Accel == Nvidia {
+					derivedOpts["bf"] = "0"
+				} else {
+					derivedOpts["bf"] = "3"
+				}
 			case ProfileNone:
 				if p.Accel == Nvidia {
-					p.VideoEncoder.Opts["bf"] = "0"
+					derivedOpts["bf"] = "0"
 				} else {
-					p.VideoEncoder.Opts["bf"] = "3"
+					derivedOpts["bf"] = "3"
 				}
 			default:
 				return params, finalizer, ErrTranscoderPrf
 			}
-			if p.Profile.Framerate == 0 && p.Accel == Nvidia {
+			if p.Profile.Level > 0 {
+				if p.Accel != Netint {
+					derivedOpts["level"] = H264LevelName(p.Profile.Level)
+				} else {
+					glog.Warning("Ignoring H.264 level on Netint, which picks its own")
+				}
+			}
+			if (p.Profile.Framerate == 0 || fpsCapped) && p.Accel == Nvidia {
 				// When the decoded video contains non-monotonic increases in PTS (common with OBS)
 				// & when B-frames are enabled nvenc struggles at calculating correct DTS
 				// XXX so we disable B-frames altogether to avoid PTS < DTS errors
-				if p.VideoEncoder.Opts["bf"] != "0" {
-					p.VideoEncoder.Opts["bf"] = "0"
+				if mergeOpts(derivedOpts, profileOpts)["bf"] != "0" {
 					glog.Warning("Forcing max_b_frames=0 for nvenc, as it can't handle those well with timestamp passthrough")
 				}
+				forcedOpts["bf"] = "0"
 			}
 		}
 
@@ -737,12 +888,14 @@ func createCOutputParams(input *TranscodeOptionsIn, ps []TranscodeOptions) ([]C.
 			}
 			// Check for intra-only
 			if param.GOP == GOPIntraOnly {
-				p.VideoEncoder.Opts["g"] = "0"
+				forcedOpts["g"] = "0"
+			} else if input.KeyframeAlign != KeyframeAlignNone {
+				// keyframes are placed by the alignment instead, set below
 			} else {
-				if param.Framerate > 0 {
+				if param.Framerate > 0 && !fpsCapped {
 					gop := param.GOP.Seconds()
 					interval := strconv.Itoa(int(gop * float64(param.Framerate)))
-					p.VideoEncoder.Opts["g"] = interval
+					forcedOpts["g"] = interval
 				} else {
 					gopMs = int(param.GOP.Milliseconds())
 				}
@@ -772,20 +925,31 @@ func createCOutputParams(input *TranscodeOptionsIn, ps []TranscodeOptions) ([]C.
 		if muxName != "" {
 			muxOpts.name = C.CString(muxName)
 		}
+		encoderOpts := mergeOpts(mergeOpts(derivedOpts, profileOpts), p.VideoEncoder.Opts)
+		if input.KeyframeAlign != KeyframeAlignNone && param.GOP != GOPIntraOnly {
+			// encoder-picked keyframes would be placed differently per output
+			forcedOpts["g"] = strconv.Itoa(alignedGOP)
+			if len(p.VideoEncoder.Name) <= 0 {
+				forcedOpts = mergeOpts(forcedOpts, noSceneCutOpts(encoder, encoderOpts))
+			}
+		}
 		vidOpts := C.component_opts{
 			name: C.CString(encoder),
-			opts: newAVOpts(p.VideoEncoder.Opts),
+			opts: newAVOpts(mergeOpts(encoderOpts, forcedOpts)),
 		}
-		audioEncoder := p.AudioEncoder.Name
+		audioEncoder, audioEncoderOpts := p.AudioEncoder.Name, p.AudioEncoder.Opts
 		if audioEncoder == "" {
-			audioEncoder = "aac"
+			audioEncoder, audioEncoderOpts = defaultAudioEncoder(param.Audio, audioEncoderOpts)
 		}
 		audioOpts := C.component_opts{
 			name: C.CString(audioEncoder),
-			opts: newAVOpts(p.AudioEncoder.Opts),
+			opts: newAVOpts(audioEncoderOpts),
+		}
+		fromMs, toMs, clipMode, clipClosed := clipParams(input, p)
+		closed := C.int(0)
+		if clipClosed {
+			closed = C.int(1)
 		}
-		fromMs := int(p.From.Milliseconds())
-		toMs := int(p.To.Milliseconds())
 		vfilt := C.CString(filters)
 		isDNN := C.int(0)
 		if p.Detector != nil {
@@ -795,7 +959,8 @@ func createCOutputParams(input *TranscodeOptionsIn, ps []TranscodeOptions) ([]C.
 		xcoderOutParams := C.CString(xcoderOutParamsStr)
 		params[i] = C.output_params{fname: oname, fps: fps,
 			w: C.int(w), h: C.int(h), bitrate: C.int(bitrate),
-			gop_time: C.int(gopMs), from: C.int(fromMs), to: C.int(toMs),
+			gop_time: C.int(gopMs), from: C.int(fromMs), to: C.int(toMs), clip_mode: clipMode, clip_closed: closed,
+			kf_align: C.int(input.KeyframeAlign), kf_interval: C.int(input.KeyframeInterval.Milliseconds()),
 			muxer: muxOpts, audio: audioOpts, video: vidOpts,
 			vfilters: vfilt, sfilters: nil, is_dnn: isDNN, xcoderParams: xcoderOutParams}
 		if p.CalcSign {
@@ -855,6 +1020,16 @@ func destroyCOutputParams(params []C.output_params) {
 }
 
 func (t *Transcoder) Transcode(input *TranscodeOptionsIn, ps []TranscodeOptions) (*TranscodeResults, error) {
+	res, err := t.transcode(input, ps)
+	if isUnrecoverable(err) {
+		panic(err)
+	}
+	return res, err
+}
+
+// transcode is Transcode without the panic on unrecoverable errors, for
+// callers that are able to replace the session instead, like TranscoderPool
+func (t *Transcoder) transcode(input *TranscodeOptionsIn, ps []TranscodeOptions) (*TranscodeResults, error) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 	if t.stopped || t.handle == nil {
@@ -863,14 +1038,26 @@ func (t *Transcoder) Transcode(input *TranscodeOptionsIn, ps []TranscodeOptions)
 	if input == nil {
 		return nil, ErrTranscoderInp
 	}
+	m := metrics.Current()
+	m.TranscodeSession(t.used)
+	t.used = true
 	var reopendemux bool
 	reopendemux = false
+	var configChange ConfigChange
+	var reinitialized bool
+	var inputFormat, probedFormat MediaFormatInfo
 	// don't read metadata for pipe input, because it can't seek back and av_find_input_format in the decoder will fail
 	if !strings.HasPrefix(strings.ToLower(input.Fname), "pipe:") {
+		probeStart := time.Now()
 		status, format, err := GetCodecInfo(input.Fname)
+		m.TranscodeStage(metrics.StageProbe, time.Since(probeStart))
 		if err != nil {
 			return nil, err
 		}
+		if err := input.Limits.checkFormat(format); err != nil {
+			return nil, err
+		}
+		probedFormat = format
 		videoTrackPresent := format.Vcodec != ""
 		if status == CodecStatusOk && videoTrackPresent {
 			// We don't return error in case status != CodecStatusOk because proper error would be returned later in the logic.
@@ -880,6 +1067,7 @@ func (t *Transcoder) Transcode(input *TranscodeOptionsIn, ps []TranscodeOptions)
 			if err != nil {
 				return nil, err
 			}
+			inputFormat = format
 		}
 		if !t.started {
 			// NeedsBypass is state where video is present in container & without any frames
@@ -893,37 +1081,61 @@ func (t *Transcoder) Transcode(input *TranscodeOptionsIn, ps []TranscodeOptions)
 			// Stream is either OK or completely broken, let the transcoder handle it
 			t.started = true
 		} else {
-			// check if we need to reopen demuxer because added audio in video
-			// TODO: fixes like that are needed because handling of cfg change in
-			// LPMS is a joke. We need to decide whether LPMS should support full
-			// dynamic config one day and either implement it there, or implement
-			// some generic workaround for the problem in Go code, such as marking
-			// config changes as significant/insignificant and re-creating the instance
-			// if the former type change happens
-			if format.Acodec != "" && !isAudioAllDrop(ps) {
+			configChange = classifyConfigChange(t.lastFormat, format)
+			if configChange.Significant() && !input.Transmuxing {
+				// the running pipeline can't adapt, so set it up from scratch
+				glog.Infof("Reinitializing transcode session, input changed: %s", configChange)
+				if C.lpms_transcode_reinit(t.handle) < 0 {
+					return nil, ErrDNNInitialize
+				}
+				reinitialized = true
+				// the source changed, and with it whether it can be copied
+				t.passthrough, t.passthroughPeaks = nil, nil
+				t.lastacodec = format.Acodec
+			} else if format.Acodec != "" && !isAudioAllDrop(ps) {
+				// check if we need to reopen demuxer because added audio in video
 				if (t.lastacodec == "") || (t.lastacodec != "" && t.lastacodec != format.Acodec) {
 					reopendemux = true
 					t.lastacodec = format.Acodec
 				}
 			}
 		}
+		if videoTrackPresent {
+			t.lastFormat = format
+		}
 	}
+	ps, t.passthrough = resolvePassthrough(ps, probedFormat, t.passthrough)
+	decodeAudio := hasAutoAudio(ps)
+	ps = resolveAutoAudio(ps, probedFormat)
+	setupStart := time.Now()
 	hw_type, err := accelDeviceType(input.Accel)
 	if err != nil {
 		return nil, err
 	}
 	for _, p := range ps {
-		if p.From != 0 || p.To != 0 {
+		if p.From != 0 || p.To != 0 || !p.Clip.IsZero() {
 			if p.VideoEncoder.Name == "drop" || p.VideoEncoder.Name == "copy" {
-				glog.Warning("Could clip only when transcoding video")
+				glog.Warning("Could clip only when transcoding video; use SmartCut for copied video")
 				return nil, ErrTranscoderClipConfig
 			}
 			if p.From < 0 || p.To > 0 && p.From > 0 && p.To < p.From {
 				glog.Warning("'To' should be after 'From'")
 				return nil, ErrTranscoderClipConfig
 			}
+			if !p.Clip.IsZero() {
+				if err := validateClipWindow(input, p); err != nil {
+					return nil, err
+				}
+			}
 		}
 	}
+	if err := ValidateTranscodeOptions(ps, inputFormat); err != nil {
+		return nil, err
+	}
+	if input.KeyframeAlign == KeyframeAlignTime && input.KeyframeInterval < time.Millisecond {
+		glog.Warning("Keyframe alignment by time needs an interval")
+		return nil, ErrTranscoderGOP
+	}
 	if input.Transmuxing {
 		t.started = true
 	}
@@ -946,11 +1158,24 @@ func (t *Transcoder) Transcode(input *TranscodeOptionsIn, ps []TranscodeOptions)
 	defer C.free(unsafe.Pointer(fname))
 	xcoderParams := C.CString("")
 	defer C.free(unsafe.Pointer(xcoderParams))
+	sessionTag := input.SessionTag
+	if sessionTag == "" {
+		sessionTag = fmt.Sprintf("transcoder-%p", t.handle)
+	}
+	session := C.CString(sessionTag)
+	defer C.free(unsafe.Pointer(session))
 	inp := &C.input_params{fname: fname, hw_type: hw_type, device: device, xcoderParams: xcoderParams,
-		handle: t.handle}
+		handle: t.handle, session: session, limits: input.Limits.cLimits(),
+		error_tolerance:         C.enum_lpms_error_tolerance(input.ErrorTolerance),
+		ts_mode:                 C.enum_lpms_ts_mode(input.TimestampMode),
+		discontinuity_threshold: C.int(discontinuityMillis(input.DiscontinuityThreshold))}
 	if input.Transmuxing {
 		inp.transmuxe = 1
 	}
+	if decodeAudio {
+		inp.decode_audio = 1
+	}
+	inp.frame_offset = C.int64_t(input.frameOffset)
 	results := make([]C.output_results, len(ps))
 	decoded := &C.output_results{}
 	var (
@@ -961,13 +1186,17 @@ func (t *Transcoder) Transcode(input *TranscodeOptionsIn, ps []TranscodeOptions)
 		paramsPointer = (*C.output_params)(&params[0])
 		resultsPointer = (*C.output_results)(&results[0])
 	}
+	m.TranscodeStage(metrics.StageSetup, time.Since(setupStart))
 	if reopendemux {
 		// forcefully close and open demuxer
+		reopenStart := time.Now()
 		ret := int(C.lpms_transcode_reopen_demux(inp))
+		m.TranscodeStage(metrics.StageReopenDemux, time.Since(reopenStart))
 		if ret != 0 {
 			if LogTranscodeErrors {
 				glog.Error("Reopen demux returned : ", ErrorMap[ret])
 			}
+			m.TranscodeError(ret)
 			return nil, ErrorMap[ret]
 		}
 	}
@@ -984,17 +1213,28 @@ func (t *Transcoder) Transcode(input *TranscodeOptionsIn, ps []TranscodeOptions)
 		use_new_transcode = 0
 	}
 
+	transcodeStart := time.Now()
 	ret := int(C.lpms_transcode(inp, paramsPointer, resultsPointer, C.int(len(params)), decoded, use_new_transcode))
+	elapsed := time.Since(transcodeStart)
+	m.TranscodeStage(metrics.StageTranscode, elapsed)
 	if ret != 0 {
 		if LogTranscodeErrors {
 			glog.Error("Transcoder Return : ", ErrorMap[ret])
 		}
-		if ret == int(C.lpms_ERR_UNRECOVERABLE) {
-			panic(ErrorMap[ret])
-		}
+		m.TranscodeError(ret)
 		return nil, ErrorMap[ret]
 	}
+	if elapsed > 0 {
+		if inputFormat.Duration > 0 {
+			m.TranscodeRealtimeRatio(inputFormat.Duration.Seconds() / elapsed.Seconds())
+		}
+		m.TranscodeFPS(float64(decoded.frames) / elapsed.Seconds())
+	}
 	tr := make([]MediaInfo, len(ps))
+	var misalignments []KeyframeMisalignment
+	if input.KeyframeAlign != KeyframeAlignNone {
+		misalignments = keyframeMisalignments(ps, results)
+	}
 	for i, r := range results {
 		tr[i] = MediaInfo{
 			Frames: int(r.frames),
@@ -1012,12 +1252,30 @@ func (t *Transcoder) Transcode(input *TranscodeOptionsIn, ps []TranscodeOptions)
 				tr[i].DetectData = res
 			}
 		}
+		if ps[i].Profile.Passthrough {
+			tr[i].Passthrough = passthroughInfo(ps[i], probedFormat)
+			t.passthroughPeaks = peakBitrate(t.passthroughPeaks, i, tr[i].Passthrough)
+		}
 	}
 	dec := MediaInfo{
 		Frames: int(decoded.frames),
 		Pixels: int64(decoded.pixels),
 	}
-	return &TranscodeResults{Encoded: tr, Decoded: dec}, nil
+	return &TranscodeResults{
+		Encoded:               tr,
+		Decoded:               dec,
+		ConfigChange:          configChange,
+		Reinitialized:         reinitialized,
+		KeyframeMisalignments: misalignments,
+		Corruption:            corruption(decoded),
+		Discontinuities:       discontinuities(decoded),
+	}, nil
+}
+
+// isUnrecoverable is true when the transcode session is left in a state it
+// can't be used from anymore
+func isUnrecoverable(err error) bool {
+	return err != nil && err == ErrorMap[int(C.lpms_ERR_UNRECOVERABLE)]
 }
 
 func (t *Transcoder) Discontinuity() {
@@ -20,6 +20,8 @@ var lpmsErrors = []struct {
 	{Code: C.lpms_ERR_INPUT_CODEC, Desc: "Unsupported input codec"},
 	{Code: C.lpms_ERR_INPUT_NOKF, Desc: "No keyframes in input"},
 	{Code: C.lpms_ERR_UNRECOVERABLE, Desc: "Unrecoverable state, restart process"},
+	{Code: C.lpms_ERR_INPUT_LIMITS, Desc: "Input exceeds limits"},
+	{Code: C.lpms_ERR_INPUT_CORRUPT, Desc: "Corrupt input"},
 }
 
 func error_map() map[int]error {
@@ -50,6 +52,12 @@ func error_map() map[int]error {
 
 var ErrorMap = error_map()
 
+// ErrTranscoderInputLimits is returned for input exceeding its InputLimits
+var ErrTranscoderInputLimits = ErrorMap[int(C.lpms_ERR_INPUT_LIMITS)]
+
+// ErrTranscoderInputCorrupt is returned for corrupt input with ErrorToleranceStrict
+var ErrTranscoderInputCorrupt = ErrorMap[int(C.lpms_ERR_INPUT_CORRUPT)]
+
 func non_retryable_errs() []string {
 	errs := []string{}
 	// Add in Cgo LPMS specific errors
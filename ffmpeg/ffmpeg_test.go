@@ -798,6 +798,36 @@ func TestTranscoder_EncoderOpts(t *testing.T) {
 	run(cmd)
 }
 
+func TestTranscoder_EncoderOptsPrecedence(t *testing.T) {
+	run, dir := setupTest(t)
+	defer os.RemoveAll(dir)
+
+	prof := P144p30fps16x9
+	prof.EncoderOpts = "bf=3"
+	noB := P144p30fps16x9
+	noB.EncoderOpts = "bf=0"
+	in := &TranscodeOptionsIn{Fname: "../transcoder/test.ts"}
+	_, err := Transcode3(in, []TranscodeOptions{{
+		// the caller's options override the profile's
+		Oname:        dir + "/caller.ts",
+		Profile:      prof,
+		VideoEncoder: ComponentOptions{Name: "libx264", Opts: map[string]string{"bf": "0"}},
+	}, {
+		// the profile's override the defaults derived from it, three B-frames
+		Oname:   dir + "/profile.ts",
+		Profile: noB,
+	}})
+	require.NoError(t, err)
+
+	cmd := `
+    ffprobe -loglevel warning -select_streams v -show_frames caller.ts > caller.frames
+    ffprobe -loglevel warning -select_streams v -show_frames profile.ts > profile.frames
+    grep -c pict_type=B caller.frames | grep -x 0
+    grep -c pict_type=B profile.frames | grep -x 0
+  `
+	assert.True(t, run(cmd))
+}
+
 func TestTranscoder_StreamCopy(t *testing.T) {
 	run, dir := setupTest(t)
 	defer os.RemoveAll(dir)
@@ -0,0 +1,135 @@
+package ffmpeg
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+	"unsafe"
+)
+
+// #include <stdlib.h>
+// #include "extras.h"
+import "C"
+
+var ErrTranscoderFiller = errors.New("TranscoderInvalidFiller")
+
+// DefaultFillerFramerate is the frame rate of fillers for sources whose frame
+// rate is unknown
+const DefaultFillerFramerate = 30
+
+// FillerOptions describe what stands in for the source over a gap
+type FillerOptions struct {
+	// Length of the gap, and the source timestamp it opens at; usually
+	// where the previous segment ended
+	Duration time.Duration
+	Start    time.Duration
+	// Picture shown for the whole filler, fitted into the frame; black if
+	// empty
+	Image string
+	// Source format the filler imitates. Defaults to that of the last
+	// segment the Transcoder transcoded, so only needed for new sessions.
+	Format MediaFormatInfo
+}
+
+// GenerateFiller transcodes a slate, or black video, with silent audio into
+// the outputs, standing in for the source over a gap. The filler is made to
+// look like the segments of the session: it goes through the same decoder,
+// filters and encoders without rebuilding them, so encoded renditions come
+// out with their usual codec parameters and with timestamps following the
+// TimestampMode of the input, and players decode them without a reset. The
+// input options apply as with Transcode, but for the file name.
+//
+// Outputs copying the source video get the filler as generated: in the source
+// codec, size and frame rate, but encoded with parameters of its own (for
+// H264, usually Constrained Baseline without B-frames), so players need to
+// treat it as a discontinuity, as they would a change of source.
+func (t *Transcoder) GenerateFiller(input *TranscodeOptionsIn, ps []TranscodeOptions, opts FillerOptions) (*TranscodeResults, error) {
+	format := opts.Format
+	if format.Width <= 0 || format.Height <= 0 {
+		t.mu.Lock()
+		format = t.lastFormat
+		t.mu.Unlock()
+	}
+	if opts.Duration <= 0 || format.Width <= 0 || format.Height <= 0 || format.Vcodec == "" {
+		return nil, ErrTranscoderFiller
+	}
+	dir, err := ioutil.TempDir("", "lpms-filler")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "filler.ts")
+
+	vfilters, afilters := fillerFilters(opts, format)
+	cfname := C.CString(fname)
+	defer C.free(unsafe.Pointer(cfname))
+	cvcodec := C.CString(format.Vcodec)
+	defer C.free(unsafe.Pointer(cvcodec))
+	cvfilters := C.CString(vfilters)
+	defer C.free(unsafe.Pointer(cvfilters))
+	cacodec := C.CString(format.Acodec)
+	defer C.free(unsafe.Pointer(cacodec))
+	cafilters := C.CString(afilters)
+	defer C.free(unsafe.Pointer(cafilters))
+	cstart := C.int64_t(opts.Start / time.Microsecond)
+	ret := int(C.lpms_generate_filler(cfname, cvcodec, C.int(format.PixFormat.RawValue), cvfilters,
+		cacodec, cafilters, cstart))
+	if ret != 0 {
+		return nil, ErrorMap[ret]
+	}
+
+	in := TranscodeOptionsIn{}
+	if input != nil {
+		in = *input
+	}
+	in.Fname = fname
+	return t.Transcode(&in, ps)
+}
+
+// GenerateFiller is Transcoder.GenerateFiller in a session of its own, for
+// which opts.Format has to be set
+func GenerateFiller(input *TranscodeOptionsIn, ps []TranscodeOptions, opts FillerOptions) (*TranscodeResults, error) {
+	t := NewTranscoder()
+	defer t.StopTranscoder()
+	return t.GenerateFiller(input, ps, opts)
+}
+
+// fillerFilters are the source filtergraphs of the filler video and audio,
+// at the size, frame rate and sample rate and layout of the source
+func fillerFilters(opts FillerOptions, format MediaFormatInfo) (string, string) {
+	fps := strconv.Itoa(DefaultFillerFramerate)
+	if format.Framerate > 0 {
+		fps = strconv.FormatFloat(format.Framerate, 'f', -1, 64)
+	}
+	w, h := format.Width, format.Height
+	secs := fmt.Sprintf("%f", opts.Duration.Seconds())
+	var vfilters string
+	if opts.Image == "" {
+		vfilters = fmt.Sprintf("color=c=black:s=%dx%d:r=%s:d=%s", w, h, fps, secs)
+	} else {
+		// repeat the one decoded picture at the frame rate, letterboxed
+		vfilters = fmt.Sprintf("movie=filename='%s',loop=loop=-1:size=1,setpts=N/(%s)/TB,fps=%s,"+
+			"scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2,trim=duration=%s",
+			ffmpegStrEscape(opts.Image), fps, fps, w, h, w, h, secs)
+	}
+	vfilters += ",setsar=1"
+	if format.Acodec == "" {
+		return vfilters, ""
+	}
+	rate := format.SampleRate
+	if rate <= 0 {
+		rate = encodedAudioSampleRate
+	}
+	layout := "stereo"
+	if format.ChannelLayout != 0 {
+		layout = fmt.Sprintf("0x%x", format.ChannelLayout)
+	} else if format.Channels > 0 {
+		layout = fmt.Sprintf("%dc", format.Channels)
+	}
+	afilters := fmt.Sprintf("anullsrc=r=%d:cl=%s,atrim=duration=%s", rate, layout, secs)
+	return vfilters, afilters
+}
@@ -0,0 +1,133 @@
+package ffmpeg
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFiller_Options(t *testing.T) {
+	assert := assert.New(t)
+	format := MediaFormatInfo{Vcodec: "h264", Width: 256, Height: 144, Acodec: "aac", SampleRate: 48000, Channels: 1}
+	ps := []TranscodeOptions{{Oname: "out.ts", Profile: P144p30fps16x9}}
+	_, err := GenerateFiller(nil, ps, FillerOptions{Format: format})
+	assert.Equal(ErrTranscoderFiller, err)
+	_, err = GenerateFiller(nil, ps, FillerOptions{Duration: time.Second})
+	assert.Equal(ErrTranscoderFiller, err, "no session to take the format from")
+
+	v, a := fillerFilters(FillerOptions{Duration: 1500 * time.Millisecond}, format)
+	assert.Equal("color=c=black:s=256x144:r=30:d=1.500000,setsar=1", v)
+	assert.Equal("anullsrc=r=48000:cl=1c,atrim=duration=1.500000", a)
+	format.Framerate, format.ChannelLayout = 29.97, 0x3
+	v, a = fillerFilters(FillerOptions{Duration: time.Second, Image: "a:b.png"}, format)
+	assert.True(strings.HasPrefix(v, `movie=filename='a\:b.png',loop=loop=-1:size=1,setpts=N/(29.97)/TB`), v)
+	assert.Equal("anullsrc=r=48000:cl=0x3,atrim=duration=1.000000", a)
+	format.Acodec = ""
+	_, a = fillerFilters(FillerOptions{Duration: time.Second}, format)
+	assert.Empty(a)
+}
+
+func TestTranscoder_Filler(t *testing.T) {
+	run, dir := setupTest(t)
+	defer os.RemoveAll(dir)
+
+	cmd := `
+    ffmpeg -loglevel warning -f lavfi -i testsrc=size=320x240:rate=30 -f lavfi -i sine -t 2 -c:v libx264 -c:a aac -f mpegts seg0.ts
+    # the source resumes two seconds after seg0 ends
+    ffmpeg -loglevel warning -i seg0.ts -c copy -output_ts_offset 4 seg2.ts
+  `
+	run(cmd)
+
+	_, format, err := GetCodecInfo(dir + "/seg0.ts")
+	require.NoError(t, err)
+	for _, mode := range []TimestampMode{TimestampPassthrough, TimestampContinuous} {
+		tc := NewTranscoder()
+		in := &TranscodeOptionsIn{TimestampMode: mode}
+		out := func(i int) []TranscodeOptions {
+			return []TranscodeOptions{
+				{Oname: fmt.Sprintf("%s/out-%d-%d.ts", dir, mode, i), Profile: P144p30fps16x9},
+				{
+					Oname:        fmt.Sprintf("%s/copy-%d-%d.ts", dir, mode, i),
+					VideoEncoder: ComponentOptions{Name: "copy"},
+					AudioEncoder: ComponentOptions{Name: "copy"},
+				},
+			}
+		}
+		in.Fname = dir + "/seg0.ts"
+		_, err := tc.Transcode(in, out(0))
+		require.NoError(t, err)
+		// spliced into the session in place of the missing segment
+		res, err := tc.GenerateFiller(in, out(1), FillerOptions{
+			Duration: 2 * time.Second,
+			Start:    format.StartTime + 2*time.Second,
+		})
+		require.NoError(t, err)
+		assert.False(t, res.Reinitialized, "same source parameters")
+		assert.InDelta(t, 60, res.Encoded[0].Frames, 2)
+		in.Fname = dir + "/seg2.ts"
+		res, err = tc.Transcode(in, out(2))
+		require.NoError(t, err)
+		assert.Empty(t, res.Discontinuities, "the filler ends where the source resumes")
+		tc.StopTranscoder()
+
+		var starts []time.Duration
+		for i := 0; i < 3; i++ {
+			_, f, err := GetCodecInfo(out(i)[0].Oname)
+			require.NoError(t, err)
+			assert.Equal(t, "h264", f.Vcodec)
+			assert.Equal(t, "aac", f.Acodec)
+			assert.Equal(t, 256, f.Width)
+			starts = append(starts, f.StartTime)
+			// copies get the filler in the source codec and size
+			_, f, err = GetCodecInfo(out(i)[1].Oname)
+			require.NoError(t, err)
+			assert.Equal(t, "h264", f.Vcodec)
+			assert.Equal(t, format.Width, f.Width)
+			assert.Equal(t, format.Height, f.Height)
+		}
+		tolerance := float64(100 * time.Millisecond)
+		assert.InDelta(t, starts[0]+2*time.Second, starts[1], tolerance)
+		assert.InDelta(t, starts[0]+4*time.Second, starts[2], tolerance)
+
+		cmd = fmt.Sprintf(`
+    # the renditions' segments play through as one stream
+    cat out-%[1]d-0.ts out-%[1]d-1.ts out-%[1]d-2.ts > spliced.ts
+    ffmpeg -loglevel error -i spliced.ts -f null - 2> decode.log
+    [ ! -s decode.log ]
+    # with the same codec parameters throughout
+    for i in 0 1 2; do
+      ffprobe -loglevel warning -select_streams v -show_entries stream=profile,level,pix_fmt -of csv=p=0 out-%[1]d-$i.ts > params-$i
+    done
+    diff params-0 params-1
+    diff params-0 params-2
+    # copies only keep the source's pixel format; the filler has its own SPS
+    ffprobe -loglevel warning -select_streams v -show_entries stream=pix_fmt -of csv=p=0 copy-%[1]d-0.ts > copy-params-0
+    ffprobe -loglevel warning -select_streams v -show_entries stream=pix_fmt -of csv=p=0 copy-%[1]d-1.ts > copy-params-1
+    diff copy-params-0 copy-params-1
+  `, mode)
+		run(cmd)
+	}
+}
+
+func TestFiller_Slate(t *testing.T) {
+	run, dir := setupTest(t)
+	defer os.RemoveAll(dir)
+
+	run(`ffmpeg -loglevel warning -f lavfi -i testsrc=size=320x240 -frames:v 1 slate.png`)
+
+	format := MediaFormatInfo{Vcodec: "h264", Width: 640, Height: 360, Framerate: 25, Acodec: "aac", SampleRate: 44100, Channels: 2}
+	res, err := GenerateFiller(nil, []TranscodeOptions{{Oname: dir + "/out.ts", Profile: P144p30fps16x9}},
+		FillerOptions{Duration: time.Second, Start: 10 * time.Second, Image: dir + "/slate.png", Format: format})
+	require.NoError(t, err)
+	assert.InDelta(t, 30, res.Encoded[0].Frames, 2)
+	_, f, err := GetCodecInfo(dir + "/out.ts")
+	require.NoError(t, err)
+	assert.Equal(t, 256, f.Width)
+	assert.Equal(t, 144, f.Height)
+	assert.Equal(t, "aac", f.Acodec)
+}
@@ -69,6 +69,12 @@ int init_video_filters(struct input_ctx *ictx, struct output_ctx *octx)
       vf->graph = avfilter_graph_alloc();
     }
     vf->pts_diff = INT64_MIN;
+    if (octx->fps.den && ictx->frame_offset) {
+      // Place the frames on the fps filter's timeline where they would be
+      // in a transcode of the whole stream; see filtergraph_write
+      AVStream *vst = ictx->ic->streams[ictx->vi];
+      vf->custom_pts = ictx->frame_offset * av_rescale_q(1, av_inv_q(vst->r_frame_rate), vst->time_base);
+    }
     if (!outputs || !inputs || !vf->graph) {
       ret = AVERROR(ENOMEM);
       LPMS_ERR(vf_init_cleanup, "Unable to allocate filters");
@@ -67,6 +67,14 @@ struct output_ctx {
 
   int64_t clip_from, clip_to, clip_from_pts, clip_to_pts, clip_started, clip_start_pts, clip_start_pts_found; // for clipping
   int64_t clip_audio_from_pts, clip_audio_to_pts, clip_audio_start_pts, clip_audio_start_pts_found; // for clipping
+  int clip_mode; // enum lpms_clip_mode
+  int clip_closed; // window ended before the current segment
+
+  int kf_align; // enum lpms_kf_align
+  int64_t kf_interval, kf_slot; // for time alignment, in AV_TIME_BASE units
+  int64_t kf_source_pts; // pending input keyframe for source alignment
+
+  int64_t *ts_offset; // of the input, added to output timestamps; AV_TIME_BASE
 
   AVFilterGraph **dnn_filtergraph;
   int is_dnn_profile; //if not dnn profile: 0
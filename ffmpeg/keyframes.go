@@ -0,0 +1,126 @@
+package ffmpeg
+
+//#include "transcoder.h"
+import "C"
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// KeyframeAlignment forces the keyframes of all encoded video outputs of a
+// Transcode call onto the same input timestamps, so players can switch
+// between renditions at any keyframe. Positive profile GOPs and the
+// encoders' own keyframe intervals are ignored while aligning, and scene cut
+// keyframes are turned off for encoders picked by the transcoder. Outputs
+// only line up where they have frames at the same timestamps, so renditions
+// should share a frame rate or have frame rates dividing the alignment
+// interval.
+type KeyframeAlignment int
+
+const (
+	KeyframeAlignNone KeyframeAlignment = iota
+	// On the first frame of every TranscodeOptionsIn.KeyframeInterval of the
+	// input timeline, counting from zero
+	KeyframeAlignTime
+	// On the first frame at or after each keyframe of the input
+	KeyframeAlignSource
+)
+
+// Encoder keyframe interval while aligning, for the encoders to never place
+// keyframes of their own; libx264 takes it as infinite
+const alignedGOP = 1 << 30
+
+// noSceneCutOpts turns off scene cut detection of the named encoder, keeping
+// what else is in the encoder options opts
+func noSceneCutOpts(encoder string, opts map[string]string) map[string]string {
+	switch encoder {
+	case "libx264":
+		return map[string]string{"sc_threshold": "0"}
+	case "libx265":
+		params := "scenecut=0"
+		if prev := opts["x265-params"]; prev != "" {
+			params = prev + ":" + params
+		}
+		return map[string]string{"x265-params": params}
+	}
+	return nil
+}
+
+// Keyframes closer than this are taken to be at the same timestamp
+const keyframeAlignTolerance = time.Millisecond
+
+// KeyframeMisalignment is a keyframe found in some of the encoded video
+// outputs but not in others
+type KeyframeMisalignment struct {
+	PTS time.Duration
+	// Indices of the outputs with and without a keyframe at PTS
+	Present, Missing []int
+}
+
+func keyframeMisalignments(ps []TranscodeOptions, results []C.output_results) []KeyframeMisalignment {
+	var outputs []int
+	var keyframes [][]time.Duration
+	until := time.Duration(math.MaxInt64)
+	for i, p := range ps {
+		if p.Detector != nil || p.VideoEncoder.Name == "copy" || p.VideoEncoder.Name == "drop" {
+			continue
+		}
+		n := int(results[i].nb_keyframes)
+		kfs := make([]time.Duration, 0, n)
+		for j := 0; j < n && j < C.MAX_KEYFRAMES; j++ {
+			kfs = append(kfs, time.Duration(results[i].keyframes[j])*time.Microsecond)
+		}
+		if n > C.MAX_KEYFRAMES && len(kfs) > 0 && kfs[len(kfs)-1] < until {
+			// the rest weren't kept, so only compare as far as all were
+			until = kfs[len(kfs)-1]
+		}
+		outputs = append(outputs, i)
+		keyframes = append(keyframes, kfs)
+	}
+	return compareKeyframes(outputs, keyframes, until)
+}
+
+// compareKeyframes lists the keyframes up to until that aren't in all of the
+// outputs, given the keyframes of each
+func compareKeyframes(outputs []int, keyframes [][]time.Duration, until time.Duration) []KeyframeMisalignment {
+	if len(outputs) < 2 {
+		return nil
+	}
+	type keyframe struct {
+		pts    time.Duration
+		output int
+	}
+	var all []keyframe
+	for i, kfs := range keyframes {
+		for _, pts := range kfs {
+			if pts <= until {
+				all = append(all, keyframe{pts, outputs[i]})
+			}
+		}
+	}
+	sort.SliceStable(all, func(i, j int) bool { return all[i].pts < all[j].pts })
+
+	var misalignments []KeyframeMisalignment
+	for start := 0; start < len(all); {
+		present := map[int]bool{}
+		end := start
+		for ; end < len(all) && all[end].pts-all[start].pts < keyframeAlignTolerance; end++ {
+			present[all[end].output] = true
+		}
+		if len(present) < len(outputs) {
+			m := KeyframeMisalignment{PTS: all[start].pts}
+			for _, o := range outputs {
+				if present[o] {
+					m.Present = append(m.Present, o)
+				} else {
+					m.Missing = append(m.Missing, o)
+				}
+			}
+			misalignments = append(misalignments, m)
+		}
+		start = end
+	}
+	return misalignments
+}
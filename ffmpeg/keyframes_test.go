@@ -0,0 +1,98 @@
+package ffmpeg
+
+import (
+	"math"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyframes_Compare(t *testing.T) {
+	s := time.Second
+	ms := time.Millisecond
+	forever := time.Duration(math.MaxInt64)
+
+	// aligned, allowing for rounding
+	kfs := [][]time.Duration{{0, s, 2 * s}, {0, s + 100*time.Microsecond, 2 * s}}
+	assert.Nil(t, compareKeyframes([]int{0, 1}, kfs, forever))
+	// a single output is always aligned
+	assert.Nil(t, compareKeyframes([]int{2}, kfs[:1], forever))
+
+	kfs = [][]time.Duration{{0, s, 2 * s}, {0, s + 33*ms, 2 * s}, {0, 1500 * ms, 2 * s}}
+	assert.Equal(t, []KeyframeMisalignment{
+		{PTS: s, Present: []int{0}, Missing: []int{2, 3}},
+		{PTS: s + 33*ms, Present: []int{2}, Missing: []int{0, 3}},
+		{PTS: 1500 * ms, Present: []int{3}, Missing: []int{0, 2}},
+	}, compareKeyframes([]int{0, 2, 3}, kfs, forever))
+
+	// keyframes past what all outputs kept aren't compared
+	assert.Equal(t, []KeyframeMisalignment{
+		{PTS: s, Present: []int{0}, Missing: []int{2, 3}},
+	}, compareKeyframes([]int{0, 2, 3}, kfs, s))
+}
+
+func TestKeyframes_Align(t *testing.T) {
+	run, dir := setupTest(t)
+	defer os.RemoveAll(dir)
+
+	for _, align := range []KeyframeAlignment{KeyframeAlignTime, KeyframeAlignSource} {
+		in := &TranscodeOptionsIn{
+			Fname:            "../transcoder/test.ts",
+			KeyframeAlign:    align,
+			KeyframeInterval: time.Second,
+		}
+		p240 := P240p30fps16x9
+		// a profile GOP doesn't get in the way
+		p240.GOP = 3 * time.Second
+		res, err := Transcode3(in, []TranscodeOptions{
+			{Oname: dir + "/a.ts", Profile: P144p30fps16x9},
+			{Oname: dir + "/b.ts", Profile: p240},
+			{Oname: dir + "/c.ts", VideoEncoder: ComponentOptions{Name: "copy"}},
+		})
+		require.NoError(t, err)
+		assert.Empty(t, res.KeyframeMisalignments)
+
+		cmd := `
+			ffprobe -loglevel warning -select_streams v -show_entries packet=pts,flags -of csv=p=0 a.ts | grep K > a.out
+			ffprobe -loglevel warning -select_streams v -show_entries packet=pts,flags -of csv=p=0 b.ts | grep K > b.out
+			diff -u a.out b.out
+		`
+		if align == KeyframeAlignTime {
+			// a keyframe every second of the input
+			cmd += `[ $(wc -l < a.out) -ge 4 ]`
+		}
+		run(cmd)
+	}
+
+	_, err := Transcode3(&TranscodeOptionsIn{Fname: "../transcoder/test.ts", KeyframeAlign: KeyframeAlignTime},
+		[]TranscodeOptions{{Oname: dir + "/a.ts", Profile: P144p30fps16x9}})
+	assert.Equal(t, ErrTranscoderGOP, err)
+}
+
+func TestKeyframes_AlignLongInterval(t *testing.T) {
+	run, dir := setupTest(t)
+	defer os.RemoveAll(dir)
+
+	run(`ffmpeg -loglevel warning -f lavfi -i testsrc=size=320x240:rate=30 -t 12 -c:v libx264 -g 600 -f mpegts in.ts`)
+
+	// intervals past the encoders' default of 250 frames
+	in := &TranscodeOptionsIn{Fname: dir + "/in.ts", KeyframeAlign: KeyframeAlignTime, KeyframeInterval: 10 * time.Second}
+	_, err := Transcode3(in, []TranscodeOptions{{Oname: dir + "/out.ts", Profile: P144p30fps16x9}})
+	require.NoError(t, err)
+	cmd := `
+		ffprobe -loglevel warning -select_streams v -show_entries packet=flags -of csv=p=0 out.ts | grep -c K | grep -x 2
+	`
+	assert.True(t, run(cmd))
+}
+
+func TestKeyframes_NoSceneCutOpts(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(map[string]string{"sc_threshold": "0"}, noSceneCutOpts("libx264", nil))
+	assert.Equal(map[string]string{"x265-params": "scenecut=0"}, noSceneCutOpts("libx265", nil))
+	assert.Equal(map[string]string{"x265-params": "keyint=60:scenecut=0"},
+		noSceneCutOpts("libx265", map[string]string{"x265-params": "keyint=60"}))
+	assert.Nil(noSceneCutOpts("h264_nvenc", nil))
+}
@@ -0,0 +1,170 @@
+package ffmpeg
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Candidate renditions of BuildLadder when the policy doesn't list any
+var DefaultLadder = []VideoProfile{
+	P720p30fps16x9,
+	P576p30fps16x9,
+	P360p30fps16x9,
+	P240p30fps16x9,
+	P144p30fps16x9,
+}
+
+// LadderPolicy configures BuildLadder
+type LadderPolicy struct {
+	// Candidate renditions; defaults to DefaultLadder. Only the smaller side
+	// of each resolution is kept, the larger one follows the source.
+	Profiles []VideoProfile
+	// Add a Passthrough rendition copying the source, or encoding it at its
+	// resolution and frame rate if it can't be copied
+	Passthrough bool
+	// Encoder of the renditions, and where it runs, to apply encoder limits
+	Encoder VideoCodec
+	Accel   Acceleration
+	// Maximum number of renditions, passthrough included; zero for no limit
+	MaxRenditions int
+}
+
+// BuildLadder derives the renditions for a source from the policy. Renditions
+// that would upscale are dropped, the rest take on the source aspect ratio
+// and orientation and don't exceed the source frame rate. Bitrates are scaled
+// from the candidate's by the change in pixels per second. Renditions are
+// ordered from highest to lowest resolution.
+func BuildLadder(info MediaFormatInfo, policy LadderPolicy) []VideoProfile {
+	if info.Width <= 0 || info.Height <= 0 {
+		return nil
+	}
+	candidates := policy.Profiles
+	if len(candidates) == 0 {
+		candidates = DefaultLadder
+	}
+	short, long := info.Width, info.Height
+	if short > long {
+		short, long = long, short
+	}
+
+	var ladder []VideoProfile
+	seen := map[string]bool{}
+	add := func(p VideoProfile) {
+		if limits, ok := nvidiaCodecSizeLimts[p.Encoder]; ok && policy.Accel == Nvidia {
+			w, h, _ := VideoProfileResolution(p)
+			if size := (Size{w, h}); !size.Valid(&limits) {
+				if err := limits.Clamp(&p, info); err != nil {
+					return
+				}
+				// the clamp keeps the aspect ratio, which may leave a side odd;
+				// round up, to stay above the minimum, for 4:2:0 output
+				w, h, _ = VideoProfileResolution(p)
+				w, h = (w+1)/2*2, (h+1)/2*2
+				if size := (Size{w, h}); !size.Valid(&limits) {
+					return
+				}
+				p.Resolution = fmt.Sprintf("%dx%d", w, h)
+			}
+		}
+		if seen[p.Resolution] {
+			return
+		}
+		seen[p.Resolution] = true
+		ladder = append(ladder, p)
+	}
+
+	if policy.Passthrough && len(candidates) > 0 {
+		p := candidates[0]
+		p.Name = "source"
+		p.Resolution = fmt.Sprintf("%dx%d", info.Width, info.Height)
+		p.AspectRatio = aspectRatio(info.Width, info.Height)
+		// no bitrate cap, so any source within the resolution is copied
+		p.Bitrate = ""
+		p.Framerate, p.FramerateDen, p.FramerateMode = 0, 0, FramerateFixed
+		p.Encoder = policy.Encoder
+		p.Passthrough = true
+		add(p)
+	}
+	for _, c := range candidates {
+		w, h, err := VideoProfileResolution(c)
+		if err != nil || w <= 0 || h <= 0 {
+			continue
+		}
+		s := w
+		if h < s {
+			s = h
+		}
+		if s > short {
+			// would upscale
+			continue
+		}
+		l := int(math.Round(float64(s)*float64(long)/float64(short)/2)) * 2
+		w, h = l, s
+		if info.Width < info.Height {
+			w, h = s, l
+		}
+		p := c
+		fps := profileFramerate(c)
+		if info.Framerate > 0 && fps > 0 && info.Framerate < fps {
+			// don't make up frames the source doesn't have
+			p.FramerateMode = FramerateMax
+			fps = info.Framerate
+		}
+		p.Resolution = fmt.Sprintf("%dx%d", w, h)
+		p.AspectRatio = aspectRatio(w, h)
+		p.Bitrate = scaleBitrate(c, w, h, fps)
+		p.Name = DefaultProfileName(w, h, parseBitrate(p.Bitrate))
+		p.Encoder = policy.Encoder
+		add(p)
+	}
+	sort.SliceStable(ladder, func(i, j int) bool {
+		wi, hi, _ := VideoProfileResolution(ladder[i])
+		wj, hj, _ := VideoProfileResolution(ladder[j])
+		return wi*hi > wj*hj
+	})
+	if policy.MaxRenditions > 0 && len(ladder) > policy.MaxRenditions {
+		ladder = ladder[:policy.MaxRenditions]
+	}
+	return ladder
+}
+
+func profileFramerate(p VideoProfile) float64 {
+	if p.Framerate == 0 {
+		return 0
+	}
+	den := p.FramerateDen
+	if den == 0 {
+		den = 1
+	}
+	return float64(p.Framerate) / float64(den)
+}
+
+func parseBitrate(bitrate string) int {
+	b, _ := strconv.Atoi(strings.Replace(bitrate, "k", "000", 1))
+	return b
+}
+
+// scaleBitrate scales the bitrate of p to the given resolution and frame rate,
+// keeping the bits per pixel. Unknown frame rates are taken to be the same.
+func scaleBitrate(p VideoProfile, w, h int, fps float64) string {
+	pw, ph, err := VideoProfileResolution(p)
+	if err != nil || pw <= 0 || ph <= 0 {
+		return p.Bitrate
+	}
+	scale := float64(w*h) / float64(pw*ph)
+	if pfps := profileFramerate(p); pfps > 0 && fps > 0 {
+		scale *= fps / pfps
+	}
+	return fmt.Sprintf("%dk", int(math.Round(float64(parseBitrate(p.Bitrate))*scale/1000)))
+}
+
+func aspectRatio(w, h int) string {
+	a, b := w, h
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return fmt.Sprintf("%d:%d", w/a, h/a)
+}
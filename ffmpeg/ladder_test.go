@@ -0,0 +1,50 @@
+package ffmpeg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildLadder(t *testing.T) {
+	resolutions := func(ladder []VideoProfile) []string {
+		res := []string{}
+		for _, p := range ladder {
+			res = append(res, p.Resolution)
+		}
+		return res
+	}
+
+	// no upscaling, and the source aspect ratio is kept
+	info := MediaFormatInfo{Width: 854, Height: 480, Framerate: 30}
+	ladder := BuildLadder(info, LadderPolicy{})
+	assert.Equal(t, []string{"640x360", "428x240", "256x144"}, resolutions(ladder))
+	assert.Equal(t, "1200k", ladder[0].Bitrate)
+	assert.Equal(t, "16:9", ladder[0].AspectRatio)
+
+	// portrait, with a passthrough rendition
+	info = MediaFormatInfo{Width: 720, Height: 1280, Framerate: 30}
+	ladder = BuildLadder(info, LadderPolicy{Passthrough: true, MaxRenditions: 3})
+	assert.Equal(t, []string{"720x1280", "576x1024", "360x640"}, resolutions(ladder))
+	assert.Equal(t, "source", ladder[0].Name)
+	assert.Equal(t, uint(0), ladder[0].Framerate)
+	assert.True(t, ladder[0].Passthrough)
+	assert.Empty(t, ladder[0].Bitrate)
+
+	// 4:3 at 15fps: fewer pixels per second, so lower bitrates
+	info = MediaFormatInfo{Width: 640, Height: 480, Framerate: 15}
+	ladder = BuildLadder(info, LadderPolicy{Profiles: []VideoProfile{P360p30fps16x9}})
+	assert.Len(t, ladder, 1)
+	assert.Equal(t, "480x360", ladder[0].Resolution)
+	assert.Equal(t, FramerateMax, ladder[0].FramerateMode)
+	assert.Equal(t, "450k", ladder[0].Bitrate)
+
+	// encoder limits: NVENC H.264 is at least 146 pixels wide
+	info = MediaFormatInfo{Width: 720, Height: 1280, Framerate: 30}
+	ladder = BuildLadder(info, LadderPolicy{Accel: Nvidia, Encoder: H264})
+	assert.Equal(t, "146x260", ladder[len(ladder)-1].Resolution)
+	ladder = BuildLadder(info, LadderPolicy{Encoder: H264})
+	assert.Equal(t, "144x256", ladder[len(ladder)-1].Resolution)
+
+	assert.Empty(t, BuildLadder(MediaFormatInfo{}, LadderPolicy{}))
+}
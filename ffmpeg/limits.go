@@ -0,0 +1,69 @@
+package ffmpeg
+
+//#include "transcoder.h"
+import "C"
+
+import (
+	"math"
+	"time"
+)
+
+// InputLimits protect shared transcoders from hostile input. A transcode
+// exceeding any of them fails early with ErrTranscoderInputLimits. Zero
+// values don't limit.
+type InputLimits struct {
+	// Largest resolution, in either orientation
+	MaxWidth, MaxHeight int
+	// Decoded video frames per segment, and time between the first and last
+	MaxFrames   int
+	MaxDuration time.Duration
+	// Frames the filters may output for a single decoded frame; the fps
+	// filter fills timestamp gaps with duplicates
+	MaxDuplicateFrames int
+	// Largest jump in timestamps between packets of a stream
+	MaxPTSGap time.Duration
+	// Bytes
+	MaxPacketSize int
+	// Bytes of decoded frames the video decoder may hold, estimated from the
+	// frames it keeps for reference, reordering and threading
+	MaxFrameMemory int64
+}
+
+func (l InputLimits) cLimits() C.input_limits {
+	return C.input_limits{
+		max_width:        C.int(l.MaxWidth),
+		max_height:       C.int(l.MaxHeight),
+		max_frames:       C.int(l.MaxFrames),
+		max_duration:     C.int(limitMillis(l.MaxDuration)),
+		max_dup_frames:   C.int(l.MaxDuplicateFrames),
+		max_pts_gap:      C.int(limitMillis(l.MaxPTSGap)),
+		max_packet_size:  C.int(l.MaxPacketSize),
+		max_frame_memory: C.int64_t(l.MaxFrameMemory),
+	}
+}
+
+// limitMillis rounds up, so short limits don't turn into no limit
+func limitMillis(d time.Duration) int64 {
+	if d <= 0 {
+		return 0
+	}
+	ms := int64((d + time.Millisecond - 1) / time.Millisecond)
+	if ms > math.MaxInt32 {
+		ms = math.MaxInt32
+	}
+	return ms
+}
+
+// checkFormat checks the limits that the probed input format already shows
+// to be exceeded, before anything is decoded
+func (l InputLimits) checkFormat(format MediaFormatInfo) error {
+	w, h := format.Width, format.Height
+	if l.MaxWidth > 0 && l.MaxHeight > 0 &&
+		!(w <= l.MaxWidth && h <= l.MaxHeight) && !(h <= l.MaxWidth && w <= l.MaxHeight) {
+		return ErrTranscoderInputLimits
+	}
+	if l.MaxDuration > 0 && format.Duration > l.MaxDuration {
+		return ErrTranscoderInputLimits
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package ffmpeg
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInputLimits_CheckFormat(t *testing.T) {
+	assert := assert.New(t)
+	format := MediaFormatInfo{Width: 1080, Height: 1920, Duration: 2 * time.Second}
+	assert.Nil(InputLimits{}.checkFormat(format))
+	assert.Nil(InputLimits{MaxWidth: 1920, MaxHeight: 1080}.checkFormat(format), "either orientation")
+	assert.Equal(ErrTranscoderInputLimits, InputLimits{MaxWidth: 1280, MaxHeight: 720}.checkFormat(format))
+	assert.Nil(InputLimits{MaxDuration: 2 * time.Second}.checkFormat(format))
+	assert.Equal(ErrTranscoderInputLimits, InputLimits{MaxDuration: time.Second}.checkFormat(format))
+	// unknown formats pass, the transcoder checks what it decodes
+	assert.Nil(InputLimits{MaxWidth: 1280, MaxHeight: 720, MaxDuration: time.Second}.checkFormat(MediaFormatInfo{}))
+
+	assert.Equal(int64(0), limitMillis(0))
+	assert.Equal(int64(1), limitMillis(time.Microsecond))
+	assert.Equal(int64(1500), limitMillis(1500*time.Millisecond))
+}
+
+func TestTranscoder_InputLimits(t *testing.T) {
+	run, dir := setupTest(t)
+	defer os.RemoveAll(dir)
+
+	cmd := `
+    ffmpeg -loglevel warning -f lavfi -i testsrc=size=320x240:rate=10 -t 2 -c:v libx264 -f mpegts in.ts
+    # same segment again, 100 seconds later
+    ffmpeg -loglevel warning -i in.ts -c copy -output_ts_offset 100 -muxdelay 0 late.ts
+    cat in.ts late.ts > gap.ts
+  `
+	run(cmd)
+
+	prof := P144p30fps16x9
+	prof.Framerate = 60
+	transcode := func(fname string, limits InputLimits) error {
+		tc := NewTranscoder()
+		defer tc.StopTranscoder()
+		in := &TranscodeOptionsIn{Fname: dir + "/" + fname, Limits: limits}
+		_, err := tc.Transcode(in, []TranscodeOptions{{Oname: dir + "/out.ts", Profile: prof}})
+		return err
+	}
+	assert := assert.New(t)
+	assert.Nil(transcode("in.ts", InputLimits{}))
+	assert.Nil(transcode("in.ts", InputLimits{
+		MaxWidth: 240, MaxHeight: 320, MaxFrames: 30, MaxDuration: 3 * time.Second,
+		MaxDuplicateFrames: 20, MaxPTSGap: time.Second, MaxPacketSize: 1 << 20, MaxFrameMemory: 1 << 30,
+	}))
+	assert.Equal(ErrTranscoderInputLimits, transcode("in.ts", InputLimits{MaxWidth: 160, MaxHeight: 120}))
+	assert.Equal(ErrTranscoderInputLimits, transcode("in.ts", InputLimits{MaxFrames: 10}))
+	assert.Equal(ErrTranscoderInputLimits, transcode("in.ts", InputLimits{MaxDuration: time.Second}))
+	assert.Equal(ErrTranscoderInputLimits, transcode("in.ts", InputLimits{MaxDuplicateFrames: 3}))
+	assert.Equal(ErrTranscoderInputLimits, transcode("in.ts", InputLimits{MaxPacketSize: 100}))
+	assert.Equal(ErrTranscoderInputLimits, transcode("in.ts", InputLimits{MaxFrameMemory: 1000}))
+	assert.Equal(ErrTranscoderInputLimits, transcode("gap.ts", InputLimits{MaxPTSGap: time.Second}))
+}
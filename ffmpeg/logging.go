@@ -0,0 +1,172 @@
+package ffmpeg
+
+// void lpms_set_log_callback(int enabled);
+import "C"
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogHandler receives FFmpeg and transcoder log messages up to the level
+// given to InitFFmpegWithLogLevel. session names the transcode logging the
+// message: the TranscodeOptionsIn.SessionTag if set, otherwise the
+// Transcoder. It is empty for messages logged outside of a transcode,
+// including those of FFmpeg's own worker threads.
+type LogHandler func(level LogLevel, session string, msg string)
+
+// Repeats of a message beyond the burst within a window are dropped, and
+// counted in a summary passed on once the window ends. Messages repeat when
+// logged by the same session at the same level from the same format string,
+// whatever the values formatted into them.
+const (
+	logRepeatWindow = time.Second
+	logRepeatBurst  = 5
+)
+
+var logHandler struct {
+	sync.RWMutex
+	handle  LogHandler
+	limiter *logLimiter
+}
+
+// SetLogHandler sends log messages to h instead of stderr, or back to stderr
+// if h is nil. Messages repeated by a session are rate limited. Summaries of
+// repeats still pending go to the handler being replaced.
+func SetLogHandler(h LogHandler) {
+	logHandler.Lock()
+	old := logHandler.limiter
+	logHandler.handle = h
+	logHandler.limiter = newLogLimiter(h)
+	logHandler.Unlock()
+	if old != nil {
+		old.close()
+	}
+	enabled := 0
+	if h != nil {
+		enabled = 1
+	}
+	C.lpms_set_log_callback(C.int(enabled))
+}
+
+//export lpmsLogMessage
+func lpmsLogMessage(level C.int, session *C.char, format *C.char, msg *C.char) {
+	logHandler.RLock()
+	handle, limiter := logHandler.handle, logHandler.limiter
+	logHandler.RUnlock()
+	if handle == nil {
+		return
+	}
+	m := logMessage{
+		logKey: logKey{level: LogLevel(level), format: C.GoString(format)},
+		msg:    strings.TrimRight(C.GoString(msg), "\n"),
+	}
+	if m.msg == "" {
+		return
+	}
+	if session != nil {
+		m.session = C.GoString(session)
+	}
+	for _, m := range limiter.filter(m, time.Now()) {
+		handle(m.level, m.session, m.msg)
+	}
+}
+
+// logKey is what repeats of a message have in common
+type logKey struct {
+	level   LogLevel
+	session string
+	format  string
+}
+
+type logMessage struct {
+	logKey
+	msg string
+}
+
+// logLimiter counts the messages of the current window
+type logLimiter struct {
+	mu     sync.Mutex
+	handle LogHandler
+	start  time.Time
+	counts map[logKey]int
+	// first messages past the burst, in the order they got there
+	limited []logMessage
+	// passes on the summaries when the window ends
+	timer *time.Timer
+}
+
+func newLogLimiter(h LogHandler) *logLimiter {
+	return &logLimiter{handle: h, counts: map[logKey]int{}}
+}
+
+// filter returns the messages to pass on when m is logged at now: m itself
+// unless it's over the burst, preceded by summaries of the repeats dropped in
+// the previous window if it has ended
+func (l *logLimiter) filter(m logMessage, now time.Time) []logMessage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var out []logMessage
+	if now.Sub(l.start) >= logRepeatWindow {
+		out = l.flush()
+		l.start = now
+	}
+	l.counts[m.logKey]++
+	switch n := l.counts[m.logKey]; {
+	case n <= logRepeatBurst:
+		out = append(out, m)
+	case n == logRepeatBurst+1:
+		if len(l.limited) == 0 && l.handle != nil {
+			l.timer = time.AfterFunc(l.start.Add(logRepeatWindow).Sub(now), func() {
+				l.expire(time.Now())
+			})
+		}
+		l.limited = append(l.limited, m)
+	}
+	return out
+}
+
+// expire passes on the summaries of the window if it has ended by now
+func (l *logLimiter) expire(now time.Time) {
+	l.mu.Lock()
+	var out []logMessage
+	if now.Sub(l.start) >= logRepeatWindow {
+		out = l.flush()
+	}
+	l.mu.Unlock()
+	l.emit(out)
+}
+
+// close passes on the summaries of the window without waiting for its end
+func (l *logLimiter) close() {
+	l.mu.Lock()
+	out := l.flush()
+	l.mu.Unlock()
+	l.emit(out)
+}
+
+func (l *logLimiter) emit(out []logMessage) {
+	if l.handle == nil {
+		return
+	}
+	for _, m := range out {
+		l.handle(m.level, m.session, m.msg)
+	}
+}
+
+// flush returns summaries of the repeats dropped so far and clears the counts
+func (l *logLimiter) flush() []logMessage {
+	var out []logMessage
+	for _, r := range l.limited {
+		r.msg = fmt.Sprintf("%s (%d more like it dropped)", r.msg, l.counts[r.logKey]-logRepeatBurst)
+		out = append(out, r)
+	}
+	if l.timer != nil {
+		l.timer.Stop()
+		l.timer = nil
+	}
+	l.counts, l.limited = map[logKey]int{}, nil
+	return out
+}
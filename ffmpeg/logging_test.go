@@ -0,0 +1,62 @@
+package ffmpeg
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogLimiter(t *testing.T) {
+	assert := assert.New(t)
+	var handled []logMessage
+	l := newLogLimiter(func(level LogLevel, session string, msg string) {
+		handled = append(handled, logMessage{logKey{level: level, session: session}, msg})
+	})
+	start := time.Now()
+	dts := func(session string, i int) logMessage {
+		return logMessage{logKey{FFLogWarning, session, "non monotonic dts %d\n"}, fmt.Sprintf("non monotonic dts %d", i)}
+	}
+	summary := func(session string, msg string) logMessage {
+		return logMessage{logKey{level: FFLogWarning, session: session}, msg}
+	}
+
+	// repeats past the burst are dropped per session, though their values
+	// change
+	passed := 0
+	for i := 0; i < 3*logRepeatBurst; i++ {
+		passed += len(l.filter(dts("a", i), start))
+	}
+	assert.Equal(logRepeatBurst, passed)
+	assert.Equal([]logMessage{dts("b", 0)}, l.filter(dts("b", 0), start))
+
+	// the next window opens with a summary of what was dropped
+	out := l.filter(dts("b", 1), start.Add(logRepeatWindow))
+	assert.Equal([]logMessage{
+		{dts("a", 5).logKey, "non monotonic dts 5 (10 more like it dropped)"},
+		dts("b", 1),
+	}, out)
+
+	// nothing was dropped in the previous window, so no summary
+	assert.Equal([]logMessage{dts("a", 0)}, l.filter(dts("a", 0), start.Add(2*logRepeatWindow)))
+
+	// with no later message, the summary is passed on as the window ends
+	for i := 1; i < logRepeatBurst+2; i++ {
+		l.filter(dts("a", i), start.Add(2*logRepeatWindow))
+	}
+	l.expire(start.Add(2*logRepeatWindow + logRepeatWindow/2))
+	assert.Empty(handled, "the window is still open")
+	l.expire(start.Add(3 * logRepeatWindow))
+	assert.Equal([]logMessage{summary("a", "non monotonic dts 5 (2 more like it dropped)")}, handled)
+	l.expire(start.Add(4 * logRepeatWindow))
+	assert.Len(handled, 1, "passed on once")
+
+	// or when the handler is replaced
+	handled = nil
+	for i := 0; i < logRepeatBurst+1; i++ {
+		l.filter(dts("b", i), start.Add(4*logRepeatWindow))
+	}
+	l.close()
+	assert.Equal([]logMessage{summary("b", "non monotonic dts 5 (1 more like it dropped)")}, handled)
+}
@@ -793,3 +793,7 @@ func TestTranscoder_Portrait(t *testing.T) {
 func TestNvidia_DiscontinuityAudioSegment(t *testing.T) {
 	discontinuityAudioSegment(t, Nvidia)
 }
+
+func TestNvidia_AudioAuto(t *testing.T) {
+	audioAutoTest(t, Nvidia)
+}
@@ -0,0 +1,187 @@
+package ffmpeg
+
+// #include <stdlib.h>
+// #include "transcoder.h"
+import "C"
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+	"unsafe"
+)
+
+// PassthroughInfo is what the output of a Passthrough profile turned out to be
+type PassthroughInfo struct {
+	// Whether the source was copied, rather than encoded for exceeding the caps
+	Copied bool
+	// The output as a profile, with its real resolution and codec and its
+	// bitrate in bits per second; VideoProfileToVariantParams makes the
+	// master playlist entry from it. The bitrate is the highest of the
+	// output's segments so far in the session, as BANDWIDTH is to be the peak.
+	Profile VideoProfile
+}
+
+// passthroughChoice is how the output with the Passthrough profile requested
+// was resolved
+type passthroughChoice struct {
+	requested VideoProfile
+	encoder   ComponentOptions
+	profile   VideoProfile
+}
+
+// resolvePassthrough has outputs with Passthrough profiles copy the source
+// video, if allowed, and otherwise sets them up to encode it. The choices
+// made for the earlier segments of a session are kept, as a rendition
+// switching between the source and encoded parameters midstream breaks
+// decoding on players, and hardware sessions can't switch anyway. Returns
+// the choices to keep for the next segment along with the options.
+func resolvePassthrough(ps []TranscodeOptions, format MediaFormatInfo, chosen []passthroughChoice) ([]TranscodeOptions, []passthroughChoice) {
+	var resolved []TranscodeOptions
+	choices := make([]passthroughChoice, len(ps))
+	for i, p := range ps {
+		if !p.Profile.Passthrough || p.VideoEncoder.Name == "copy" || p.VideoEncoder.Name == "drop" {
+			continue
+		}
+		if resolved == nil {
+			// leave the caller's options be
+			resolved = append([]TranscodeOptions(nil), ps...)
+		}
+		if i < len(chosen) && chosen[i].requested == p.Profile {
+			resolved[i].VideoEncoder, resolved[i].Profile = chosen[i].encoder, chosen[i].profile
+		} else if passthroughCopies(p, format) {
+			resolved[i].VideoEncoder = ComponentOptions{Name: "copy"}
+		} else {
+			resolved[i].Profile = passthroughEncoding(p.Profile, format)
+		}
+		choices[i] = passthroughChoice{p.Profile, resolved[i].VideoEncoder, resolved[i].Profile}
+	}
+	if resolved == nil {
+		return ps, nil
+	}
+	return resolved, choices
+}
+
+// passthroughCopies is true if the source is within the caps of the profile
+// and the output container holds it
+func passthroughCopies(p TranscodeOptions, format MediaFormatInfo) bool {
+	w, h, _ := VideoProfileResolution(p.Profile)
+	capResolution := w > 0 && h > 0
+	capBitrate := parseBitrate(p.Profile.Bitrate)
+	if format.Vcodec == "" {
+		// nothing known about the source to check against
+		return !capResolution && capBitrate <= 0
+	}
+	if capResolution && !fitsResolution(format.Width, format.Height, w, h) {
+		return false
+	}
+	if capBitrate > 0 && (format.Bitrate <= 0 || format.Bitrate > capBitrate) {
+		return false
+	}
+	return muxerAccepts(p, format.Vcodec)
+}
+
+// passthroughEncoding is the profile to encode a source the Passthrough profile
+// p can't copy: the source resolution within the cap, and the source bitrate,
+// scaled to the resolution, within the cap
+func passthroughEncoding(p VideoProfile, format MediaFormatInfo) VideoProfile {
+	if format.Width <= 0 || format.Height <= 0 {
+		return p
+	}
+	w, h, _ := VideoProfileResolution(p)
+	if w <= 0 || h <= 0 || fitsResolution(format.Width, format.Height, w, h) {
+		w, h = format.Width, format.Height
+		p.Resolution = fmt.Sprintf("%dx%d", w, h)
+	}
+	if parseBitrate(p.Bitrate) <= 0 && format.Bitrate > 0 {
+		w, h = scaledResolution(w, h, format)
+		scale := float64(w*h) / float64(format.Width*format.Height)
+		p.Bitrate = strconv.Itoa(int(float64(format.Bitrate) * scale))
+	}
+	return p
+}
+
+// fitsResolution is true if w x h is within the cap, in either orientation
+func fitsResolution(w, h, capW, capH int) bool {
+	return w <= capW && h <= capH || h <= capW && w <= capH
+}
+
+// muxerAccepts is false if the output container is known not to hold video
+// of the named decoder's codec
+func muxerAccepts(p TranscodeOptions, decoder string) bool {
+	muxer := p.Muxer.Name
+	switch p.Profile.Format {
+	case FormatMPEGTS:
+		muxer = "mpegts"
+	case FormatMP4:
+		muxer = "mp4"
+	}
+	var cmuxer *C.char
+	if muxer != "" {
+		cmuxer = C.CString(muxer)
+		defer C.free(unsafe.Pointer(cmuxer))
+	}
+	coname := C.CString(p.Oname)
+	defer C.free(unsafe.Pointer(coname))
+	cdecoder := C.CString(decoder)
+	defer C.free(unsafe.Pointer(cdecoder))
+	ofmt := C.av_guess_format(cmuxer, coname, nil)
+	dec := C.avcodec_find_decoder_by_name(cdecoder)
+	if ofmt == nil || dec == nil {
+		// leave it to the muxer
+		return true
+	}
+	// negative when the muxer can't tell
+	return C.avformat_query_codec(ofmt, dec.id, C.FF_COMPLIANCE_NORMAL) != 0
+}
+
+// passthroughInfo describes the output of the resolved Passthrough profile p
+func passthroughInfo(p TranscodeOptions, format MediaFormatInfo) *PassthroughInfo {
+	info := &PassthroughInfo{Copied: p.VideoEncoder.Name == "copy", Profile: p.Profile}
+	prof := &info.Profile
+	if info.Copied {
+		prof.Resolution = ""
+		if format.Width > 0 && format.Height > 0 {
+			prof.Resolution = fmt.Sprintf("%dx%d", format.Width, format.Height)
+		}
+		if codec, ok := FfmpegNameToVideoCodec[format.Vcodec]; ok {
+			prof.Encoder = codec
+		}
+		prof.Profile, prof.Level = format.Profile, 0
+		prof.Framerate, prof.FramerateDen = 0, 0
+		prof.Bitrate = strconv.Itoa(format.Bitrate)
+	} else if w, h, err := VideoProfileResolution(*prof); err == nil {
+		w, h = scaledResolution(w, h, format)
+		prof.Resolution = fmt.Sprintf("%dx%d", w, h)
+	}
+	if bitrate := fileBitrate(p.Oname, format.Duration); bitrate > 0 {
+		prof.Bitrate = strconv.Itoa(bitrate)
+	}
+	return info
+}
+
+// peakBitrate raises the bitrate of info, the Passthrough output i, to the
+// highest of its segments, as kept track of in peaks
+func peakBitrate(peaks []int, i int, info *PassthroughInfo) []int {
+	for len(peaks) <= i {
+		peaks = append(peaks, 0)
+	}
+	if bitrate := parseBitrate(info.Profile.Bitrate); bitrate > peaks[i] {
+		peaks[i] = bitrate
+	}
+	if peaks[i] > 0 {
+		info.Profile.Bitrate = strconv.Itoa(peaks[i])
+	}
+	return peaks
+}
+
+// fileBitrate is the bitrate of the file written over the duration; zero if
+// either is unknown
+func fileBitrate(fname string, duration time.Duration) int {
+	fi, err := os.Stat(fname)
+	if err != nil || !fi.Mode().IsRegular() || duration <= 0 {
+		return 0
+	}
+	return int(float64(fi.Size()*8) / duration.Seconds())
+}
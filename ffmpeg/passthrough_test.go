@@ -0,0 +1,171 @@
+package ffmpeg
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolvePassthrough(t *testing.T) {
+	assert := assert.New(t)
+	source := MediaFormatInfo{Vcodec: "h264", Width: 1920, Height: 1080, Bitrate: 6000000}
+	passthrough := func(resolution, bitrate string) TranscodeOptions {
+		return TranscodeOptions{
+			Oname:   "out.ts",
+			Profile: VideoProfile{Passthrough: true, Resolution: resolution, Bitrate: bitrate, Format: FormatMPEGTS},
+		}
+	}
+	resolve := func(p TranscodeOptions, format MediaFormatInfo) TranscodeOptions {
+		ps, _ := resolvePassthrough([]TranscodeOptions{p}, format, nil)
+		return ps[0]
+	}
+
+	for _, p := range []TranscodeOptions{
+		passthrough("", ""),
+		passthrough("1920x1080", "8000k"),
+		passthrough("1080x1920", ""),
+	} {
+		assert.Equal("copy", resolve(p, source).VideoEncoder.Name, p.Profile)
+	}
+	// nothing to check an unknown source against
+	assert.Equal("copy", resolve(passthrough("", ""), MediaFormatInfo{}).VideoEncoder.Name)
+	assert.Equal("", resolve(passthrough("1920x1080", ""), MediaFormatInfo{}).VideoEncoder.Name)
+
+	// over the resolution cap: encoded at the cap, with the source bitrate scaled
+	p := resolve(passthrough("1280x720", ""), source)
+	assert.Equal("", p.VideoEncoder.Name)
+	assert.Equal("1280x720", p.Profile.Resolution)
+	assert.Equal("2666666", p.Profile.Bitrate)
+
+	// over the bitrate cap: encoded at the source resolution, at the cap
+	p = resolve(passthrough("", "4000k"), source)
+	assert.Equal("", p.VideoEncoder.Name)
+	assert.Equal("1920x1080", p.Profile.Resolution)
+	assert.Equal("4000k", p.Profile.Bitrate)
+
+	// the container can't hold the source
+	p = passthrough("", "")
+	p.Profile.Format = FormatNone
+	p.Muxer = ComponentOptions{Name: "wav"}
+	assert.Equal("", resolve(p, source).VideoEncoder.Name)
+
+	// the caller's options are left be
+	ps := []TranscodeOptions{passthrough("", "")}
+	resolvePassthrough(ps, source, nil)
+	assert.Equal("", ps[0].VideoEncoder.Name)
+
+	// choices stick for the session, while the profile stays the same
+	capped := passthrough("1280x720", "")
+	_, chosen := resolvePassthrough([]TranscodeOptions{passthrough("", ""), capped}, source, nil)
+	small := MediaFormatInfo{Vcodec: "h264", Width: 640, Height: 360, Bitrate: 1000000}
+	ps, _ = resolvePassthrough([]TranscodeOptions{passthrough("", ""), capped}, small, chosen)
+	assert.Equal("copy", ps[0].VideoEncoder.Name)
+	assert.Equal("", ps[1].VideoEncoder.Name)
+	assert.Equal("1280x720", ps[1].Profile.Resolution)
+	assert.Equal("2666666", ps[1].Profile.Bitrate)
+	ps, _ = resolvePassthrough([]TranscodeOptions{passthrough("", ""), passthrough("1920x1080", "")}, small, chosen)
+	assert.Equal("copy", ps[1].VideoEncoder.Name, "decided anew for another profile")
+
+	assert.True(fitsResolution(720, 1280, 1280, 720))
+	assert.False(fitsResolution(1280, 721, 1280, 720))
+}
+
+func TestTranscoder_Passthrough(t *testing.T) {
+	run, dir := setupTest(t)
+	defer os.RemoveAll(dir)
+
+	cmd := `
+    ffmpeg -loglevel warning -f lavfi -i testsrc=size=320x240:rate=30 -f lavfi -i sine -t 2 -c:v libx264 -b:v 500k -c:a aac -f mpegts in.ts
+  `
+	run(cmd)
+
+	passthrough := func(oname, resolution, bitrate string) TranscodeOptions {
+		return TranscodeOptions{
+			Oname:   dir + "/" + oname,
+			Profile: VideoProfile{Passthrough: true, Resolution: resolution, Bitrate: bitrate, Format: FormatMPEGTS},
+		}
+	}
+	tc := NewTranscoder()
+	defer tc.StopTranscoder()
+	res, err := tc.Transcode(&TranscodeOptionsIn{Fname: dir + "/in.ts"}, []TranscodeOptions{
+		passthrough("copied.ts", "640x480", ""),
+		passthrough("capped.ts", "160x120", ""),
+		passthrough("limited.ts", "", "100k"),
+	})
+	require.NoError(t, err)
+
+	copied := res.Encoded[0].Passthrough
+	require.NotNil(t, copied)
+	assert.True(t, copied.Copied)
+	assert.Equal(t, "320x240", copied.Profile.Resolution)
+	assert.Equal(t, H264, copied.Profile.Encoder)
+	assert.True(t, parseBitrate(copied.Profile.Bitrate) > 100000, copied.Profile.Bitrate)
+	params := VideoProfileToVariantParams(copied.Profile)
+	assert.Equal(t, "320x240", params.Resolution)
+	assert.NotZero(t, params.Bandwidth)
+
+	capped := res.Encoded[1].Passthrough
+	require.NotNil(t, capped)
+	assert.False(t, capped.Copied)
+	assert.Equal(t, "160x120", capped.Profile.Resolution)
+
+	limited := res.Encoded[2].Passthrough
+	require.NotNil(t, limited)
+	assert.False(t, limited.Copied)
+	assert.Equal(t, "320x240", limited.Profile.Resolution)
+
+	cmd = `
+    # the source video is copied as is
+    ffmpeg -loglevel warning -i in.ts -an -c:v copy -f md5 in.md5
+    ffmpeg -loglevel warning -i copied.ts -an -c:v copy -f md5 copied.md5
+    diff -u in.md5 copied.md5
+
+    ffprobe -loglevel warning -select_streams v -show_streams capped.ts | grep width=160
+    ffprobe -loglevel warning -select_streams v -show_streams limited.ts | grep width=320
+  `
+	run(cmd)
+}
+
+func TestTranscoder_PassthroughSession(t *testing.T) {
+	run, dir := setupTest(t)
+	defer os.RemoveAll(dir)
+
+	cmd := `
+    ffmpeg -loglevel warning -f lavfi -i testsrc=size=320x240:rate=30 -f lavfi -i sine -t 2 -c:v libx264 -b:v 500k -c:a aac -f mpegts in.ts
+    # same parameters, at bitrates far above and below
+    ffmpeg -loglevel warning -f lavfi -i testsrc=size=320x240:rate=30 -f lavfi -i sine -t 2 -vf noise=alls=100:allf=t -c:v libx264 -qp 0 -c:a aac -f mpegts high.ts
+    ffmpeg -loglevel warning -f lavfi -i testsrc=size=320x240:rate=30 -f lavfi -i sine -t 2 -c:v libx264 -crf 51 -c:a aac -f mpegts low.ts
+  `
+	run(cmd)
+
+	tc := NewTranscoder()
+	defer tc.StopTranscoder()
+	// what the first segment decides holds for the rest of the session
+	var bitrates []int
+	for i, seg := range []string{"in", "high", "low"} {
+		res, err := tc.Transcode(&TranscodeOptionsIn{Fname: dir + "/" + seg + ".ts"}, []TranscodeOptions{{
+			Oname:   fmt.Sprintf("%s/copied-%d.ts", dir, i),
+			Profile: VideoProfile{Passthrough: true, Bitrate: "5000k", Format: FormatMPEGTS},
+		}, {
+			Oname:   fmt.Sprintf("%s/encoded-%d.ts", dir, i),
+			Profile: VideoProfile{Passthrough: true, Bitrate: "400k", Format: FormatMPEGTS},
+		}})
+		require.NoError(t, err, seg)
+		assert.True(t, res.Encoded[0].Passthrough.Copied, seg)
+		assert.False(t, res.Encoded[1].Passthrough.Copied, seg)
+		bitrates = append(bitrates, parseBitrate(res.Encoded[0].Passthrough.Profile.Bitrate))
+	}
+	// the peak so far, for the master playlist's BANDWIDTH
+	assert.True(t, bitrates[1] > bitrates[0], bitrates)
+	assert.Equal(t, bitrates[1], bitrates[2])
+
+	cmd = `
+    ffmpeg -loglevel warning -i high.ts -an -c:v copy -f md5 high.md5
+    ffmpeg -loglevel warning -i copied-1.ts -an -c:v copy -f md5 copied.md5
+    diff -u high.md5 copied.md5
+  `
+	run(cmd)
+}
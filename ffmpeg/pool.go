@@ -0,0 +1,303 @@
+package ffmpeg
+
+import (
+	"sync"
+	"time"
+)
+
+// TranscoderPoolOptions configures a TranscoderPool. Zero caps mean no limit.
+type TranscoderPoolOptions struct {
+	// MaxSessions caps the total number of sessions
+	MaxSessions int
+	// MaxSessionsPerDevice caps the number of sessions on a single device.
+	// Devices are told apart by acceleration and TranscodeOptionsIn.Device,
+	// so all software sessions share one device.
+	MaxSessionsPerDevice int
+	// MaxSessionsPerAccel caps the number of sessions per acceleration
+	MaxSessionsPerAccel map[Acceleration]int
+	// IdleTimeout is how long an unused session is kept for its stream
+	// before being stopped. Zero keeps idle sessions until capacity is needed.
+	IdleTimeout time.Duration
+}
+
+// TranscoderPool keeps one transcode session per stream ID, so segments of the
+// same stream reuse their (hardware) session, while limiting the number of
+// sessions opened per device and per acceleration. Requests that don't fit are
+// queued and served in priority order once capacity frees up; idle sessions of
+// other streams are evicted first to make room.
+type TranscoderPool struct {
+	opts     TranscoderPoolOptions
+	mu       *sync.Mutex
+	sessions map[string]*poolSession
+	live     map[*poolSession]struct{}
+	waiters  []*poolWaiter
+	seq      uint64
+	stopped  bool
+	done     chan struct{}
+
+	newTranscoder func() transcodeSession
+}
+
+// transcodeSession is the part of Transcoder used by the pool
+type transcodeSession interface {
+	transcode(input *TranscodeOptionsIn, ps []TranscodeOptions) (*TranscodeResults, error)
+	Discontinuity()
+	StopTranscoder()
+}
+
+type poolDevice struct {
+	accel  Acceleration
+	device string
+}
+
+type poolSession struct {
+	streamID string
+	dev      poolDevice
+	t        transcodeSession
+	refs     int
+	lastUsed time.Time
+	closed   bool
+}
+
+type poolWaiter struct {
+	streamID string
+	dev      poolDevice
+	priority int
+	seq      uint64
+	ready    chan *poolSession
+}
+
+func NewTranscoderPool(opts TranscoderPoolOptions) *TranscoderPool {
+	p := &TranscoderPool{
+		opts:          opts,
+		mu:            &sync.Mutex{},
+		sessions:      make(map[string]*poolSession),
+		live:          make(map[*poolSession]struct{}),
+		done:          make(chan struct{}),
+		newTranscoder: func() transcodeSession { return NewTranscoder() },
+	}
+	if opts.IdleTimeout > 0 {
+		go p.evictIdle()
+	}
+	return p
+}
+
+// Transcode runs the transcode on the session of the given stream, creating it
+// if needed. Calls with a higher priority are served first when the pool is at
+// capacity. If the session ends up in an unrecoverable state, it is discarded
+// and the error returned; the next call for the stream gets a new session.
+func (p *TranscoderPool) Transcode(streamID string, priority int, input *TranscodeOptionsIn, ps []TranscodeOptions) (*TranscodeResults, error) {
+	if input == nil {
+		return nil, ErrTranscoderInp
+	}
+	s, err := p.acquire(streamID, priority, poolDevice{accel: input.Accel, device: input.Device})
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.t.transcode(input, ps)
+	p.release(s, err)
+	return res, err
+}
+
+// Discontinuity signals a discontinuity on the session of the given stream
+func (p *TranscoderPool) Discontinuity(streamID string) {
+	p.mu.Lock()
+	s, ok := p.sessions[streamID]
+	p.mu.Unlock()
+	if ok {
+		s.t.Discontinuity()
+	}
+}
+
+// Release stops the session of the given stream once it is no longer in use
+func (p *TranscoderPool) Release(streamID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if s, ok := p.sessions[streamID]; ok {
+		p.removeSession(s)
+		p.schedule()
+	}
+}
+
+// Len returns the number of streams holding a session
+func (p *TranscoderPool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.sessions)
+}
+
+// Stop stops all sessions; queued and later calls fail with ErrTranscoderStp
+func (p *TranscoderPool) Stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopped {
+		return
+	}
+	p.stopped = true
+	close(p.done)
+	for _, s := range p.sessions {
+		p.removeSession(s)
+	}
+	for _, w := range p.waiters {
+		w.ready <- nil
+	}
+	p.waiters = nil
+}
+
+func (p *TranscoderPool) acquire(streamID string, priority int, dev poolDevice) (*poolSession, error) {
+	p.mu.Lock()
+	if p.stopped {
+		p.mu.Unlock()
+		return nil, ErrTranscoderStp
+	}
+	if s, ok := p.sessions[streamID]; ok {
+		if s.dev == dev {
+			s.refs++
+			p.mu.Unlock()
+			return s, nil
+		}
+		// stream moved to another device
+		p.removeSession(s)
+	}
+	p.seq++
+	w := &poolWaiter{
+		streamID: streamID,
+		dev:      dev,
+		priority: priority,
+		seq:      p.seq,
+		ready:    make(chan *poolSession, 1),
+	}
+	// keep waiters sorted by priority, then by arrival
+	i := len(p.waiters)
+	for i > 0 && p.waiters[i-1].priority < priority {
+		i--
+	}
+	p.waiters = append(p.waiters, nil)
+	copy(p.waiters[i+1:], p.waiters[i:])
+	p.waiters[i] = w
+	p.schedule()
+	p.mu.Unlock()
+
+	s := <-w.ready
+	if s == nil {
+		return nil, ErrTranscoderStp
+	}
+	return s, nil
+}
+
+func (p *TranscoderPool) release(s *poolSession, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s.refs--
+	s.lastUsed = time.Now()
+	if isUnrecoverable(err) {
+		p.removeSession(s)
+	} else if s.closed && s.refs == 0 {
+		p.stopSession(s)
+	}
+	p.schedule()
+}
+
+// schedule hands out sessions to waiters in order, as long as they fit.
+// Waiters that don't fit don't block the ones behind them, which may be
+// headed to another device. Must be called with the lock held.
+func (p *TranscoderPool) schedule() {
+	for i := 0; i < len(p.waiters); {
+		w := p.waiters[i]
+		s, ok := p.sessions[w.streamID]
+		if ok && s.dev == w.dev {
+			s.refs++
+		} else if p.makeRoom(w.dev) {
+			s = &poolSession{
+				streamID: w.streamID,
+				dev:      w.dev,
+				t:        p.newTranscoder(),
+				refs:     1,
+			}
+			p.sessions[w.streamID] = s
+			p.live[s] = struct{}{}
+		} else {
+			i++
+			continue
+		}
+		p.waiters = append(p.waiters[:i], p.waiters[i+1:]...)
+		w.ready <- s
+	}
+}
+
+// makeRoom checks whether a new session fits on the device, evicting
+// least recently used idle sessions if necessary
+func (p *TranscoderPool) makeRoom(dev poolDevice) bool {
+	for {
+		var total, perDev, perAccel int
+		for s := range p.live {
+			total++
+			if s.dev == dev {
+				perDev++
+			}
+			if s.dev.accel == dev.accel {
+				perAccel++
+			}
+		}
+		totalFull := p.opts.MaxSessions > 0 && total >= p.opts.MaxSessions
+		devFull := p.opts.MaxSessionsPerDevice > 0 && perDev >= p.opts.MaxSessionsPerDevice
+		accelMax := p.opts.MaxSessionsPerAccel[dev.accel]
+		accelFull := accelMax > 0 && perAccel >= accelMax
+		if !totalFull && !devFull && !accelFull {
+			return true
+		}
+		var victim *poolSession
+		for _, s := range p.sessions {
+			if s.refs > 0 || devFull && s.dev != dev || accelFull && s.dev.accel != dev.accel {
+				continue
+			}
+			if victim == nil || s.lastUsed.Before(victim.lastUsed) {
+				victim = s
+			}
+		}
+		if victim == nil {
+			return false
+		}
+		p.removeSession(victim)
+	}
+}
+
+// removeSession detaches the session from its stream and stops it once
+// it is no longer in use. Must be called with the lock held.
+func (p *TranscoderPool) removeSession(s *poolSession) {
+	if p.sessions[s.streamID] == s {
+		delete(p.sessions, s.streamID)
+	}
+	s.closed = true
+	if s.refs == 0 {
+		p.stopSession(s)
+	}
+}
+
+func (p *TranscoderPool) stopSession(s *poolSession) {
+	if _, ok := p.live[s]; !ok {
+		return
+	}
+	delete(p.live, s)
+	s.t.StopTranscoder()
+}
+
+func (p *TranscoderPool) evictIdle() {
+	ticker := time.NewTicker(p.opts.IdleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case now := <-ticker.C:
+			p.mu.Lock()
+			for _, s := range p.sessions {
+				if s.refs == 0 && now.Sub(s.lastUsed) >= p.opts.IdleTimeout {
+					p.removeSession(s)
+				}
+			}
+			p.schedule()
+			p.mu.Unlock()
+		}
+	}
+}
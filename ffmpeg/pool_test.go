@@ -0,0 +1,246 @@
+package ffmpeg
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSession struct {
+	mu      sync.Mutex
+	block   chan struct{}
+	err     error
+	calls   int
+	stopped bool
+}
+
+func (f *fakeSession) transcode(input *TranscodeOptionsIn, ps []TranscodeOptions) (*TranscodeResults, error) {
+	if f.block != nil {
+		<-f.block
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	return &TranscodeResults{}, f.err
+}
+
+func (f *fakeSession) Discontinuity() {}
+
+func (f *fakeSession) StopTranscoder() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stopped = true
+}
+
+func (f *fakeSession) isStopped() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.stopped
+}
+
+func newFakePool(opts TranscoderPoolOptions) (*TranscoderPool, chan *fakeSession) {
+	p := NewTranscoderPool(opts)
+	created := make(chan *fakeSession, 100)
+	p.newTranscoder = func() transcodeSession {
+		f := &fakeSession{}
+		created <- f
+		return f
+	}
+	return p, created
+}
+
+func TestTranscoderPool_Reuse(t *testing.T) {
+	p, created := newFakePool(TranscoderPoolOptions{})
+	defer p.Stop()
+	in := &TranscodeOptionsIn{Accel: Software}
+
+	_, err := p.Transcode("a", 0, in, nil)
+	require.NoError(t, err)
+	_, err = p.Transcode("a", 0, in, nil)
+	require.NoError(t, err)
+	assert.Len(t, created, 1)
+	f := <-created
+	assert.Equal(t, 2, f.calls)
+	assert.Equal(t, 1, p.Len())
+
+	// another device gets a new session; the old one is stopped
+	_, err = p.Transcode("a", 0, &TranscodeOptionsIn{Accel: Nvidia, Device: "0"}, nil)
+	require.NoError(t, err)
+	assert.Len(t, created, 1)
+	assert.True(t, f.isStopped())
+
+	p.Release("a")
+	assert.Equal(t, 0, p.Len())
+	assert.True(t, (<-created).isStopped())
+
+	_, err = p.Transcode("a", 0, nil, nil)
+	assert.Equal(t, ErrTranscoderInp, err)
+}
+
+func TestTranscoderPool_Caps(t *testing.T) {
+	p, created := newFakePool(TranscoderPoolOptions{
+		MaxSessionsPerDevice: 2,
+		MaxSessionsPerAccel:  map[Acceleration]int{Nvidia: 3},
+	})
+	defer p.Stop()
+	sw := &TranscodeOptionsIn{Accel: Software}
+
+	// idle sessions are evicted, least recently used first
+	for _, id := range []string{"a", "b", "a", "c"} {
+		_, err := p.Transcode(id, 0, sw, nil)
+		require.NoError(t, err)
+	}
+	a, b, c := <-created, <-created, <-created
+	assert.False(t, a.isStopped())
+	assert.True(t, b.isStopped())
+	assert.False(t, c.isStopped())
+	assert.Equal(t, 2, p.Len())
+
+	// per device and per accel caps
+	for _, id := range []string{"0a", "0b", "1a", "1b"} {
+		_, err := p.Transcode("nv"+id, 0, &TranscodeOptionsIn{Accel: Nvidia, Device: id[:1]}, nil)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 5, p.Len())
+	p.mu.Lock()
+	perDev := map[string]int{}
+	for s := range p.live {
+		if s.dev.accel == Nvidia {
+			perDev[s.dev.device]++
+		}
+	}
+	p.mu.Unlock()
+	// nv1b evicted nv0a to stay within the accel cap
+	assert.Equal(t, map[string]int{"0": 1, "1": 2}, perDev)
+}
+
+func TestTranscoderPool_Priority(t *testing.T) {
+	p, created := newFakePool(TranscoderPoolOptions{MaxSessions: 1})
+	defer p.Stop()
+	sw := &TranscodeOptionsIn{Accel: Software}
+
+	block := make(chan struct{})
+	p.newTranscoder = func() transcodeSession {
+		f := &fakeSession{block: block}
+		created <- f
+		return f
+	}
+	done := make(chan string, 3)
+	run := func(id string, prio int) {
+		_, err := p.Transcode(id, prio, sw, nil)
+		assert.NoError(t, err)
+		done <- id
+	}
+	waitQueued := func(n int) {
+		for i := 0; i < 100; i++ {
+			p.mu.Lock()
+			l := len(p.waiters)
+			p.mu.Unlock()
+			if l == n {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+		t.Fatal("timed out waiting for queue")
+	}
+	go run("busy", 0)
+	<-created
+	go run("low", 1)
+	waitQueued(1)
+	go run("high", 2)
+	waitQueued(2)
+
+	block <- struct{}{}
+	assert.Equal(t, "busy", <-done)
+	<-created
+	block <- struct{}{}
+	assert.Equal(t, "high", <-done)
+	<-created
+	block <- struct{}{}
+	assert.Equal(t, "low", <-done)
+
+	// queued calls fail once the pool is stopped
+	go run("busy", 0)
+	<-created
+	go func() {
+		_, err := p.Transcode("queued", 0, sw, nil)
+		assert.Equal(t, ErrTranscoderStp, err)
+		done <- "queued"
+	}()
+	waitQueued(1)
+	p.Stop()
+	assert.Equal(t, "queued", <-done)
+	block <- struct{}{}
+	assert.Equal(t, "busy", <-done)
+	_, err := p.Transcode("a", 0, sw, nil)
+	assert.Equal(t, ErrTranscoderStp, err)
+}
+
+func TestTranscoderPool_Unrecoverable(t *testing.T) {
+	var errUnrecoverable error
+	for _, e := range ErrorMap {
+		if e.Error() == "Unrecoverable state, restart process" {
+			errUnrecoverable = e
+		}
+	}
+	require.NotNil(t, errUnrecoverable)
+
+	p, created := newFakePool(TranscoderPoolOptions{})
+	defer p.Stop()
+	sw := &TranscodeOptionsIn{Accel: Software}
+
+	_, err := p.Transcode("a", 0, sw, nil)
+	require.NoError(t, err)
+	f := <-created
+	f.err = errUnrecoverable
+	// no panic; the broken session is replaced on the next call
+	_, err = p.Transcode("a", 0, sw, nil)
+	assert.Equal(t, errUnrecoverable, err)
+	assert.True(t, f.isStopped())
+	assert.Equal(t, 0, p.Len())
+	_, err = p.Transcode("a", 0, sw, nil)
+	assert.NoError(t, err)
+	assert.False(t, (<-created).isStopped())
+}
+
+func TestTranscoderPool_IdleTimeout(t *testing.T) {
+	p, created := newFakePool(TranscoderPoolOptions{IdleTimeout: 20 * time.Millisecond})
+	defer p.Stop()
+
+	_, err := p.Transcode("a", 0, &TranscodeOptionsIn{Accel: Software}, nil)
+	require.NoError(t, err)
+	f := <-created
+	assert.Equal(t, 1, p.Len())
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 0, p.Len())
+	assert.True(t, f.isStopped())
+}
+
+func TestTranscoderPool_Software(t *testing.T) {
+	run, dir := setupTest(t)
+	defer os.RemoveAll(dir)
+
+	cmd := `
+    # set up initial input; truncate test.ts file
+    ffmpeg -loglevel warning -i "$1"/../transcoder/test.ts -c:a copy -c:v copy -t 1 test.ts
+  `
+	run(cmd)
+
+	p := NewTranscoderPool(TranscoderPoolOptions{MaxSessions: 1})
+	defer p.Stop()
+	in := &TranscodeOptionsIn{Fname: dir + "/test.ts", Accel: Software}
+	out := []TranscodeOptions{{
+		Oname:   dir + "/out.ts",
+		Profile: P144p30fps16x9,
+	}}
+	for _, id := range []string{"a", "a", "b"} {
+		res, err := p.Transcode(id, 0, in, out)
+		require.NoError(t, err)
+		assert.NotZero(t, res.Encoded[0].Frames)
+	}
+	assert.Equal(t, 1, p.Len())
+}
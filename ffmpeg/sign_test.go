@@ -3,6 +3,7 @@ package ffmpeg
 import (
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"io/ioutil"
 	"math/rand"
 	"os"
@@ -169,3 +170,97 @@ func Test_SignDataCompare(t *testing.T) {
 		}
 	}
 }
+
+func Test_SignDataCompareDetails(t *testing.T) {
+	res, err := CompareSignatureDetailsByPath("../data/sign_sw1.bin", "../data/sign_nv1.bin", nil)
+	assert.NoError(t, err)
+	assert.True(t, res.Match)
+	assert.Equal(t, 1.0, res.Score)
+	assert.Equal(t, 37, res.MatchedFrames)
+	assert.Equal(t, 0, res.UnmatchedFrames)
+	assert.Equal(t, 0, res.FrameCountDiff)
+
+	res, err = CompareSignatureDetailsByPath("../data/sign_sw1.bin", "../data/sign_sw2.bin", nil)
+	assert.NoError(t, err)
+	assert.False(t, res.Match)
+	assert.Equal(t, CompareCheckSignLookup, res.FailedCheck)
+	assert.Equal(t, 0.0, res.Score)
+	assert.Equal(t, 60, res.UnmatchedFrames)
+	assert.Equal(t, 23, res.FrameCountDiff)
+
+	// thresholds are checked in order; frame count comes before the lookup
+	th := DefaultSignatureCompareThresholds
+	th.MaxFrameCountDiff = 10
+	res, err = CompareSignatureDetailsByPath("../data/sign_sw1.bin", "../data/sign_sw2.bin", &th)
+	assert.NoError(t, err)
+	assert.Equal(t, CompareCheckFrameCount, res.FailedCheck)
+
+	_, err = CompareSignatureDetailsByPath("../data/sign_sw1.bin", "../data/nodata.bin", nil)
+	assert.Error(t, err)
+
+	data0, err := ioutil.ReadFile("../data/sign_sw1.bin")
+	assert.NoError(t, err)
+	data2, err := ioutil.ReadFile("../data/sign_nv1.bin")
+	assert.NoError(t, err)
+	// one FineSignature in file; the lookup fails, but the frame itself matches
+	res, err = CompareSignatureDetailsByBuffer(data0[:289], data2, nil)
+	assert.NoError(t, err)
+	assert.False(t, res.Match)
+	assert.Equal(t, 1, res.MatchedFrames)
+	assert.Equal(t, 36, res.UnmatchedFrames)
+	th = DefaultSignatureCompareThresholds
+	th.MinMatchLevel = SignMatchNone
+	th.MinScore = 0.5
+	res, err = CompareSignatureDetailsByBuffer(data0[:289], data2, &th)
+	assert.NoError(t, err)
+	assert.Equal(t, CompareCheckScore, res.FailedCheck)
+	// zero FineSignature in file
+	_, err = CompareSignatureDetailsByBuffer(data0[:279], data2, nil)
+	assert.Equal(t, ErrSignCompare, err)
+	_, err = CompareSignatureDetailsByBuffer(nil, data2, nil)
+	assert.Equal(t, ErrEmptyData, err)
+}
+
+func Test_SignDataGenerate(t *testing.T) {
+	_, dir := setupTest(t)
+	defer os.RemoveAll(dir)
+
+	in := &TranscodeOptionsIn{Fname: "../transcoder/test.ts"}
+	out := []TranscodeOptions{{
+		Oname:        dir + "/signgen.ts",
+		Profile:      P360p30fps16x9,
+		AudioEncoder: ComponentOptions{Name: "copy"},
+		CalcSign:     true,
+	}}
+	_, err := Transcode3(in, out)
+	require.NoError(t, err)
+	transcoded, err := ioutil.ReadFile(dir + "/signgen.ts.bin")
+	require.NoError(t, err)
+
+	// signature of the rendition itself should match the one
+	// calculated while transcoding
+	data, err := GenerateSignature(dir + "/signgen.ts")
+	require.NoError(t, err)
+	res, err := CompareSignatureByBuffer(data, transcoded)
+	assert.NoError(t, err)
+	assert.True(t, res)
+
+	// keep the file around if asked to
+	data, err = GenerateSignatureFile(dir+"/signgen.ts", dir+"/out:gen.bin")
+	require.NoError(t, err)
+	written, err := ioutil.ReadFile(dir + "/out:gen.bin")
+	assert.NoError(t, err)
+	assert.Equal(t, data, written)
+
+	// signature of different content should not match
+	data, err = GenerateSignature("../data/kryp-1.ts")
+	require.NoError(t, err)
+	res, err = CompareSignatureByBuffer(data, transcoded)
+	assert.NoError(t, err)
+	assert.False(t, res)
+
+	_, err = GenerateSignature(dir + "/nonexistent.ts")
+	assert.Error(t, err)
+	_, err = GenerateSignature("")
+	assert.Equal(t, ErrTranscoderInp, err)
+}
@@ -0,0 +1,53 @@
+package ffmpeg
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"unsafe"
+)
+
+// #include <stdlib.h>
+// #include "extras.h"
+import "C"
+
+// GenerateSignature computes the MPEG-7 signature of the video track of the
+// input without transcoding it. The returned bytes have the same format as the
+// .bin files written when CalcSign is set, so they can be compared against
+// those with CompareSignatureByBuffer.
+func GenerateSignature(input string) ([]byte, error) {
+	f, err := ioutil.TempFile("", "lpms-sign-*.bin")
+	if err != nil {
+		return nil, err
+	}
+	fname := f.Name()
+	f.Close()
+	defer os.Remove(fname)
+	return GenerateSignatureFile(input, fname)
+}
+
+// GenerateSignatureFile is like GenerateSignature, but also keeps the
+// signature in the given file.
+func GenerateSignatureFile(input string, signPath string) ([]byte, error) {
+	if len(input) <= 0 || len(signPath) <= 0 {
+		return nil, ErrTranscoderInp
+	}
+	sfilters := fmt.Sprintf("signature=filename='%s'", ffmpegStrEscape(signPath))
+	cinput := C.CString(input)
+	defer C.free(unsafe.Pointer(cinput))
+	csfilters := C.CString(sfilters)
+	defer C.free(unsafe.Pointer(csfilters))
+
+	ret := int(C.lpms_generate_signature(cinput, csfilters))
+	if ret != 0 {
+		return nil, ErrorMap[ret]
+	}
+	data, err := ioutil.ReadFile(signPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, ErrEmptyData
+	}
+	return data, nil
+}
@@ -0,0 +1,301 @@
+package ffmpeg
+
+// #include <stdlib.h>
+// #include "extras.h"
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unsafe"
+)
+
+var ErrTranscoderSmartCut = errors.New("TranscoderUnsupportedSmartCut")
+
+// Source video codecs that can be smart cut, with the encoder matching them
+var smartCutEncoders = map[string]VideoCodec{
+	"h264": H264,
+	"hevc": H265,
+}
+
+// VideoKeyframes returns the timestamps of the video keyframes of a file, in
+// the order they are stored
+func VideoKeyframes(fname string) ([]time.Duration, error) {
+	cfname := C.CString(fname)
+	defer C.free(unsafe.Pointer(cfname))
+	size := 1024
+	for {
+		buf := make([]C.int64_t, size)
+		ret := int(C.lpms_video_keyframes(cfname, &buf[0], C.int(size)))
+		if ret < 0 {
+			return nil, ErrTranscoderVid
+		}
+		if ret > size {
+			// buffer was too small; rescan with the exact size
+			size = ret
+			continue
+		}
+		kfs := make([]time.Duration, ret)
+		for i := range kfs {
+			kfs[i] = time.Duration(buf[i]) * time.Microsecond
+		}
+		return kfs, nil
+	}
+}
+
+// smartCutPiece is a chunk of the split input, either copied as is or
+// re-encoded with the given clip window relative to the chunk start
+type smartCutPiece struct {
+	start    time.Duration
+	encode   bool
+	from, to time.Duration
+}
+
+// SmartCut clips a stream-copied output frame accurately. Only the GOP
+// containing the in-point and the one containing the out-point are
+// re-encoded, with the source codec, profile, resolution and frame rate;
+// the GOPs in between are copied. From and To are interpreted as in
+// Transcode. The output's VideoEncoder must be copy; audio is copied or
+// dropped as per its AudioEncoder. The joined stream carries parameter sets
+// in-band, so MP4 outputs rely on players honouring those.
+//
+// Decoded counts the frames decoded to re-encode the edges; Encoded counts
+// all video frames written to the output.
+func SmartCut(input *TranscodeOptionsIn, out TranscodeOptions) (*TranscodeResults, error) {
+	if input == nil || strings.HasPrefix(strings.ToLower(input.Fname), "pipe:") {
+		return nil, ErrTranscoderInp
+	}
+	if out.VideoEncoder.Name != "copy" || out.Detector != nil || !out.Clip.IsZero() {
+		return nil, ErrTranscoderSmartCut
+	}
+	if out.AudioEncoder.Name != "copy" && out.AudioEncoder.Name != "drop" {
+		return nil, ErrTranscoderSmartCut
+	}
+	if out.From < 0 || out.To < 0 || out.To > 0 && out.To < out.From {
+		return nil, ErrTranscoderClipConfig
+	}
+	status, format, err := GetCodecInfo(input.Fname)
+	if err != nil {
+		return nil, err
+	}
+	if status != CodecStatusOk || format.Vcodec == "" {
+		return nil, ErrTranscoderVid
+	}
+	codec, ok := smartCutEncoders[format.Vcodec]
+	if !ok {
+		return nil, ErrTranscoderSmartCut
+	}
+	kfs, err := VideoKeyframes(input.Fname)
+	if err != nil {
+		return nil, err
+	}
+	if len(kfs) == 0 {
+		return nil, ErrTranscoderVid
+	}
+	sort.Slice(kfs, func(i, j int) bool { return kfs[i] < kfs[j] })
+
+	pieces := planSmartCut(kfs, kfs[0]+out.From, kfs[0]+out.To, out.To > 0)
+	workDir, err := ioutil.TempDir("", "lpms-smartcut")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(workDir)
+
+	names, err := splitSmartCut(input.Fname, kfs[0], pieces, out.AudioEncoder, workDir)
+	if err != nil {
+		return nil, err
+	}
+	profile := VideoProfile{
+		Name:       "smartcut",
+		Resolution: fmt.Sprintf("%dx%d", format.Width, format.Height),
+		Bitrate:    smartCutBitrate(input.Fname, format.Duration),
+		Format:     FormatMPEGTS,
+		Profile:    format.Profile,
+		Encoder:    codec,
+	}
+	res := &TranscodeResults{Encoded: make([]MediaInfo, 1)}
+	parts := make([]string, len(pieces))
+	for i, piece := range pieces {
+		if !piece.encode {
+			parts[i] = names[i]
+			continue
+		}
+		parts[i] = filepath.Join(workDir, fmt.Sprintf("edge-%d.ts", i))
+		r, err := Transcode3(&TranscodeOptionsIn{
+			Fname:  names[i],
+			Accel:  input.Accel,
+			Device: input.Device,
+		}, []TranscodeOptions{{
+			Oname:        parts[i],
+			Profile:      profile,
+			Accel:        out.Accel,
+			Device:       out.Device,
+			From:         piece.from,
+			To:           piece.to,
+			AudioEncoder: out.AudioEncoder,
+			Muxer:        ComponentOptions{Name: "mpegts"},
+		}})
+		if err != nil {
+			return nil, fmt.Errorf("smart cut edge %d: %w", i, err)
+		}
+		res.Decoded.Frames += r.Decoded.Frames
+		res.Decoded.Pixels += r.Decoded.Pixels
+	}
+
+	encoded, err := joinSmartCut(parts, out)
+	if err != nil {
+		return nil, err
+	}
+	res.Encoded[0] = encoded
+	if out.CalcSign {
+		if _, err := GenerateSignatureFile(out.Oname, out.Oname+".bin"); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// planSmartCut lays out the pieces of a clip over the keyframes kfs, which
+// must be sorted. from and to are absolute; to is ignored unless hasTo.
+func planSmartCut(kfs []time.Duration, from, to time.Duration, hasTo bool) []smartCutPiece {
+	// last keyframe at or before t
+	before := func(t time.Duration) time.Duration {
+		i := sort.Search(len(kfs), func(i int) bool { return kfs[i] > t })
+		if i == 0 {
+			return kfs[0]
+		}
+		return kfs[i-1]
+	}
+	// first keyframe at or after t, if any
+	after := func(t time.Duration) (time.Duration, bool) {
+		i := sort.Search(len(kfs), func(i int) bool { return kfs[i] >= t })
+		if i == len(kfs) {
+			return 0, false
+		}
+		return kfs[i], true
+	}
+
+	k0 := before(from)
+	k1, hasK1 := after(from)
+	if !hasK1 || hasTo && k1 > to {
+		// no keyframe inside the clip; re-encode all of it
+		return []smartCutPiece{{start: k0, encode: true, from: from - k0, to: clipTo(to, k0, hasTo)}}
+	}
+	var pieces []smartCutPiece
+	if k1 > from {
+		pieces = append(pieces, smartCutPiece{start: k0, encode: true, from: from - k0})
+	}
+	if !hasTo {
+		return append(pieces, smartCutPiece{start: k1})
+	}
+	k2 := before(to)
+	if k2 > k1 {
+		pieces = append(pieces, smartCutPiece{start: k1})
+	}
+	return append(pieces, smartCutPiece{start: k2, encode: true, to: to - k2})
+}
+
+func clipTo(to, start time.Duration, hasTo bool) time.Duration {
+	if !hasTo {
+		return 0
+	}
+	return to - start
+}
+
+// splitSmartCut copies the input into chunks starting at each piece start,
+// plus one at the end of the clip, returning the chunk of each piece
+func splitSmartCut(fname string, first time.Duration, pieces []smartCutPiece, audio ComponentOptions, workDir string) ([]string, error) {
+	var times []string
+	for _, piece := range pieces {
+		if piece.start > first {
+			times = append(times, formatSegmentTime(piece.start))
+		}
+	}
+	if last := pieces[len(pieces)-1]; last.to > 0 {
+		// the segment muxer cuts at the first keyframe past the out-point
+		times = append(times, formatSegmentTime(last.start+last.to+time.Millisecond))
+	}
+	opts := map[string]string{
+		"segment_format": "mpegts",
+		// keyframe timestamps are rounded to microseconds
+		"segment_time_delta": "0.0005",
+	}
+	if len(times) > 0 {
+		opts["segment_times"] = strings.Join(times, ",")
+	} else {
+		opts["segment_time"] = strconv.Itoa(int((24 * time.Hour).Seconds()))
+	}
+	_, err := Transcode3(&TranscodeOptionsIn{Fname: fname}, []TranscodeOptions{{
+		Oname:        filepath.Join(workDir, "chunk-%05d.ts"),
+		Profile:      VideoProfile{Format: FormatNone},
+		VideoEncoder: ComponentOptions{Name: "copy"},
+		AudioEncoder: audio,
+		Muxer:        ComponentOptions{Name: "segment", Opts: opts},
+	}})
+	if err != nil {
+		return nil, err
+	}
+	names, err := filepath.Glob(filepath.Join(workDir, "chunk-*.ts"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	// the first chunk holds everything before the first cut point
+	offset := 1
+	if pieces[0].start <= first {
+		offset = 0
+	}
+	if len(names) < len(pieces)+offset {
+		return nil, ErrTranscoderSmartCut
+	}
+	return names[offset : len(pieces)+offset], nil
+}
+
+func formatSegmentTime(t time.Duration) string {
+	return strconv.FormatFloat(t.Seconds(), 'f', 6, 64)
+}
+
+// smartCutBitrate estimates the source bitrate for the re-encoded edges
+func smartCutBitrate(fname string, duration time.Duration) string {
+	info, err := os.Stat(fname)
+	if err != nil || duration <= 0 {
+		return ""
+	}
+	return strconv.FormatInt(int64(float64(info.Size()*8)/duration.Seconds()), 10)
+}
+
+// joinSmartCut transmuxes the pieces into the output with continuous
+// timestamps, returning what was written
+func joinSmartCut(parts []string, out TranscodeOptions) (MediaInfo, error) {
+	tc := NewTranscoder()
+	defer tc.StopTranscoder()
+	outs := []TranscodeOptions{{
+		Oname:        out.Oname,
+		Profile:      VideoProfile{Format: FormatNone},
+		VideoEncoder: ComponentOptions{Name: "copy"},
+		AudioEncoder: ComponentOptions{Name: "copy"},
+		Muxer:        out.Muxer,
+	}}
+	info := MediaInfo{}
+	for i, part := range parts {
+		if i > 0 {
+			// edges are re-encoded starting from zero while copied chunks keep
+			// the source timestamps
+			tc.Discontinuity()
+		}
+		r, err := tc.Transcode(&TranscodeOptionsIn{Fname: part, Transmuxing: true}, outs)
+		if err != nil {
+			return info, err
+		}
+		info.Frames += r.Decoded.Frames
+		info.Pixels += r.Decoded.Pixels
+	}
+	return info, nil
+}
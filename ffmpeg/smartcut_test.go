@@ -0,0 +1,98 @@
+package ffmpeg
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSmartCut_Plan(t *testing.T) {
+	s := time.Second
+	kfs := []time.Duration{0, 2 * s, 4 * s, 6 * s}
+	tests := []struct {
+		name     string
+		from, to time.Duration
+		hasTo    bool
+		expected []smartCutPiece
+	}{
+		{"head, copy and tail", s, 5 * s, true, []smartCutPiece{
+			{start: 0, encode: true, from: s},
+			{start: 2 * s},
+			{start: 4 * s, encode: true, to: s},
+		}},
+		{"in-point on keyframe", 2 * s, 5 * s, true, []smartCutPiece{
+			{start: 2 * s},
+			{start: 4 * s, encode: true, to: s},
+		}},
+		{"adjacent edges", s, 3 * s, true, []smartCutPiece{
+			{start: 0, encode: true, from: s},
+			{start: 2 * s, encode: true, to: s},
+		}},
+		{"within one GOP", 2500 * time.Millisecond, 3 * s, true, []smartCutPiece{
+			{start: 2 * s, encode: true, from: 500 * time.Millisecond, to: s},
+		}},
+		{"until the end", s, 0, false, []smartCutPiece{
+			{start: 0, encode: true, from: s},
+			{start: 2 * s},
+		}},
+		{"in last GOP until the end", 7 * s, 0, false, []smartCutPiece{
+			{start: 6 * s, encode: true, from: s},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, planSmartCut(kfs, tt.from, tt.to, tt.hasTo))
+		})
+	}
+}
+
+func TestSmartCut_Transcode(t *testing.T) {
+	run, dir := setupTest(t)
+	defer os.RemoveAll(dir)
+
+	// a keyframe every two seconds, and one frame per 1/30s
+	cmd := `
+    ffmpeg -loglevel warning -f lavfi -i testsrc=size=320x240:rate=30 -f lavfi -i sine -t 8 -c:v libx264 -bf 0 -g 60 -keyint_min 60 -sc_threshold 0 -c:a aac in.ts
+  `
+	run(cmd)
+
+	in := &TranscodeOptionsIn{Fname: dir + "/in.ts"}
+	out := TranscodeOptions{
+		Oname:        dir + "/clip.ts",
+		VideoEncoder: ComponentOptions{Name: "copy"},
+		AudioEncoder: ComponentOptions{Name: "copy"},
+		From:         time.Second,
+		To:           5 * time.Second,
+	}
+	res, err := SmartCut(in, out)
+	require.NoError(t, err)
+	// 1s up to and including 5s: 30 re-encoded frames from the first GOP,
+	// the 60 of the second copied, and 31 re-encoded from the third.
+	// Cutting at keyframes instead would give 60 or 180.
+	assert.Equal(t, 121, res.Encoded[0].Frames)
+	// only the two edge GOPs were decoded
+	assert.Equal(t, 120, res.Decoded.Frames)
+
+	cmd = `
+    ffprobe -loglevel warning -select_streams v -show_streams clip.ts | grep codec_name > clip-streams.out
+    echo "codec_name=h264" > clip-expected-streams.out
+    diff -u clip-expected-streams.out clip-streams.out
+    ffprobe -loglevel warning -select_streams v -count_frames -show_entries stream=nb_read_frames -of csv=p=0 clip.ts > clip-frames.out
+    echo 121 > clip-expected-frames.out
+    diff -u clip-expected-frames.out clip-frames.out
+    # decodes cleanly across the splice points
+    ffmpeg -loglevel warning -xerror -i clip.ts -f null -
+  `
+	run(cmd)
+
+	// copy clipping still goes through SmartCut only
+	_, err = Transcode3(in, []TranscodeOptions{out})
+	assert.Equal(t, ErrTranscoderClipConfig, err)
+
+	out.VideoEncoder.Name = ""
+	_, err = SmartCut(in, out)
+	assert.Equal(t, ErrTranscoderSmartCut, err)
+}
@@ -0,0 +1,19 @@
+package ffmpeg
+
+//#include "transcoder.h"
+import "C"
+
+// TimestampMode sets the timestamps of the outputs. The same offset is applied
+// to all outputs, so they stay in line with each other.
+type TimestampMode int
+
+const (
+	// Keep the input timestamps
+	TimestampPassthrough TimestampMode = C.LPMS_TS_PASSTHROUGH
+	// Start every segment from zero
+	TimestampRebase TimestampMode = C.LPMS_TS_REBASE
+	// Start the session from zero and have each segment continue where the
+	// previous one ended, whatever jumps the input makes. Carries on through
+	// restarts of the session on input changes.
+	TimestampContinuous TimestampMode = C.LPMS_TS_CONTINUOUS
+)
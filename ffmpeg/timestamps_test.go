@@ -0,0 +1,53 @@
+package ffmpeg
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTranscoder_TimestampMode(t *testing.T) {
+	run, dir := setupTest(t)
+	defer os.RemoveAll(dir)
+
+	cmd := `
+    ffmpeg -loglevel warning -f lavfi -i testsrc=size=320x240:rate=30 -f lavfi -i sine -t 2 -c:v libx264 -c:a aac -f mpegts seg0.ts
+    # the source restarted, then jumped ahead
+    cp seg0.ts seg1.ts
+    ffmpeg -loglevel warning -i seg0.ts -c copy -output_ts_offset 100 seg2.ts
+  `
+	run(cmd)
+
+	// start times of the outputs of the segments, transcoded in one session
+	starts := func(mode TimestampMode) []time.Duration {
+		tc := NewTranscoder()
+		defer tc.StopTranscoder()
+		var starts []time.Duration
+		for i := 0; i < 3; i++ {
+			oname := fmt.Sprintf("%s/out-%d-%d.ts", dir, mode, i)
+			in := &TranscodeOptionsIn{Fname: fmt.Sprintf("%s/seg%d.ts", dir, i), TimestampMode: mode}
+			_, err := tc.Transcode(in, []TranscodeOptions{{Oname: oname, Profile: P144p30fps16x9}})
+			require.NoError(t, err)
+			_, format, err := GetCodecInfo(oname)
+			require.NoError(t, err)
+			starts = append(starts, format.StartTime)
+		}
+		return starts
+	}
+	tolerance := float64(100 * time.Millisecond)
+
+	s := starts(TimestampPassthrough)
+	require.InDelta(t, s[0], s[1], tolerance)
+	require.InDelta(t, s[0]+100*time.Second, s[2], tolerance)
+
+	s = starts(TimestampRebase)
+	require.InDelta(t, s[0], s[1], tolerance)
+	require.InDelta(t, s[0], s[2], tolerance)
+
+	s = starts(TimestampContinuous)
+	require.InDelta(t, s[0]+2*time.Second, s[1], tolerance)
+	require.InDelta(t, s[0]+4*time.Second, s[2], tolerance)
+}
@@ -3,6 +3,7 @@
 #include "filter.h"
 #include "encoder.h"
 #include "logging.h"
+#include "_cgo_export.h"
 
 #include <libavcodec/avcodec.h>
 #include <libavformat/avformat.h>
@@ -19,6 +20,8 @@ const int lpms_ERR_PACKET_ONLY = FFERRTAG('P','K','O','N');
 const int lpms_ERR_FILTER_FLUSHED = FFERRTAG('F','L','F','L');
 const int lpms_ERR_OUTPUTS = FFERRTAG('O','U','T','P');
 const int lpms_ERR_UNRECOVERABLE = FFERRTAG('U', 'N', 'R', 'V');
+const int lpms_ERR_INPUT_LIMITS = FFERRTAG('I','N','L','M');
+const int lpms_ERR_INPUT_CORRUPT = FFERRTAG('I','N','C','R');
 
 //
 //  Notes on transcoder internals:
@@ -77,6 +80,9 @@ struct transcode_thread {
   struct output_ctx outputs[MAX_OUTPUT_SIZE];
 
   AVFilterGraph *dnn_filtergraph;
+  // arguments of the DNN filter, to set the filtergraph up again after the
+  // output that took it over is torn down by a reinit
+  char *dnn_args;
 
   int nb_outputs;
 };
@@ -86,6 +92,25 @@ void lpms_init(enum LPMSLogLevel max_level)
   av_log_set_level(max_level);
 }
 
+// Session of the transcode running on this thread, for log messages. FFmpeg
+// worker threads never have one.
+static __thread char *log_session = NULL;
+
+static void log_callback(void *avcl, int level, const char *fmt, va_list vl)
+{
+  char line[1024];
+  int print_prefix = 1;
+  if (level > av_log_get_level()) return;
+  av_log_format_line2(avcl, level, fmt, vl, line, sizeof(line), &print_prefix);
+  // the format tells repeats apart from messages that only share values
+  lpmsLogMessage(level, log_session, (char *)fmt, line);
+}
+
+void lpms_set_log_callback(int enabled)
+{
+  av_log_set_callback(enabled ? log_callback : av_log_default_callback);
+}
+
 //
 // Transcoder
 //
@@ -162,6 +187,24 @@ int transcode_init(struct transcode_thread *h, input_params *inp,
 
   if (!inp) LPMS_ERR(transcode_cleanup, "Missing input params")
 
+  // limits are counted per segment
+  ictx->limits = inp->limits;
+  ictx->nb_frames = 0;
+  ictx->first_frame_ts = AV_NOPTS_VALUE;
+  for (int i = 0; i < MAX_OUTPUT_SIZE; i++) {
+    ictx->last_pkt_ts[i] = AV_NOPTS_VALUE;
+  }
+  ictx->error_tolerance = inp->error_tolerance;
+  ictx->skip_gop = 0;
+  ictx->corrupt_packets = ictx->corrupt_frames = ictx->dropped_frames = 0;
+  ictx->nb_corruptions = 0;
+  ictx->ts_mode = inp->ts_mode;
+  ictx->ts_segment_started = 0;
+  ictx->discontinuity_threshold = inp->discontinuity_threshold;
+  ictx->nb_discontinuities = 0;
+  ictx->frame_offset = inp->frame_offset;
+  memset(ictx->discontinuity_checked, 0, sizeof ictx->discontinuity_checked);
+
   // by default we re-use decoder between segments of same stream
   // unless we are using SW deocder and had to re-open IO or demuxer
   if (!ictx->ic) {
@@ -202,6 +245,8 @@ int transcode_init(struct transcode_thread *h, input_params *inp,
     ret = open_audio_decoder(inp, ictx);
     if (ret < 0) LPMS_ERR(transcode_cleanup, "Unable to reopen audio decoder")
   }
+  // the tolerance may change between segments of reused decoders
+  update_error_tolerance(ictx);
 
   // populate output contexts
   for (int i = 0; i <  nb_outputs; i++) {
@@ -224,6 +269,22 @@ int transcode_init(struct transcode_thread *h, input_params *inp,
     if (params[i].gop_time) octx->gop_time = params[i].gop_time;
     if (params[i].from) octx->clip_from = params[i].from;
     if (params[i].to) octx->clip_to = params[i].to;
+    octx->clip_mode = params[i].clip_mode;
+    octx->clip_closed = params[i].clip_closed;
+    octx->kf_align = params[i].kf_align;
+    octx->kf_interval = (int64_t) params[i].kf_interval * 1000;
+    octx->ts_offset = &ictx->ts_offset;
+    if (LPMS_CLIP_RELATIVE != octx->clip_mode) {
+      // the window is given anew with every segment; a zero bound is open
+      octx->clip_from = params[i].from;
+      octx->clip_to = params[i].to;
+      octx->clip_from_pts = octx->clip_to_pts = 0;
+      octx->clip_audio_from_pts = octx->clip_audio_to_pts = 0;
+      // absolute windows are measured from zero rather than the first frame
+      octx->clip_start_pts = octx->clip_audio_start_pts = 0;
+      octx->clip_start_pts_found = LPMS_CLIP_ABSOLUTE == octx->clip_mode;
+      octx->clip_audio_start_pts_found = LPMS_CLIP_ABSOLUTE == octx->clip_mode;
+    }
     octx->dv = ictx->vi < 0 || is_drop(octx->video->name);
     octx->da = ictx->ai < 0 || is_drop(octx->audio->name);
     octx->res = &results[i];
@@ -397,6 +458,7 @@ int handle_audio_packet(struct transcode_thread *h, output_results *decoded_resu
     if (ost) {
       if (pkt->stream_index == ictx->ai) {
         // audio packet clipping
+        if (octx->clip_closed) continue;
         if (!octx->clip_audio_start_pts_found) {
           octx->clip_audio_start_pts = pkt->pts;
           octx->clip_audio_start_pts_found = 1;
@@ -414,7 +476,7 @@ int handle_audio_packet(struct transcode_thread *h, output_results *decoded_resu
       }
 
       AVPacket *opkt = av_packet_clone(pkt);
-      if (octx->clip_from && ist->index == ictx->ai) {
+      if (octx->clip_from && LPMS_CLIP_RELATIVE == octx->clip_mode && ist->index == ictx->ai) {
         opkt->pts -= octx->clip_audio_from_pts + octx->clip_audio_start_pts;
       }
       ret = mux(opkt, ist->time_base, octx, ost);
@@ -430,9 +492,14 @@ int handle_audio_packet(struct transcode_thread *h, output_results *decoded_resu
   // Try to decode
   ret = avcodec_send_packet(ictx->ac, pkt);
   if (ret < 0) {
-    LPMS_ERR_RETURN("Error sending audio packet to decoder");
+    ret = tolerate_decode_error(ictx, pkt, ret);
+    if (ret < 0) LPMS_ERR_RETURN("Error sending audio packet to decoder");
+    return 0;
   }
   ret = avcodec_receive_frame(ictx->ac, frame);
+  if (ret < 0 && ret != AVERROR(EAGAIN) && !tolerate_decode_error(ictx, pkt, ret)) {
+    return 0;
+  }
   if (ret == AVERROR(EAGAIN)) {
     // This is not really an error. It may be that packet just fed into
     // the decoder may be not enough to complete decoding. Upper level will
@@ -505,10 +572,25 @@ int handle_video_packet(struct transcode_thread *h, output_results *decoded_resu
   // Try to decode
   ret = avcodec_send_packet(ictx->vc, pkt);
   if (ret < 0) {
-    LPMS_ERR_RETURN("Error sending video packet to decoder");
+    ret = tolerate_decode_error(ictx, pkt, ret);
+    if (ret < 0) LPMS_ERR_RETURN("Error sending video packet to decoder");
+    return 0;
   }
   ictx->pkt_diff++;
   ret = avcodec_receive_frame(ictx->vc, frame);
+  if (!ret && !is_flush_frame(frame)) {
+    ret = drop_corrupt_frame(ictx, frame);
+    if (ret > 0) {
+      ictx->pkt_diff--;
+      av_frame_unref(frame);
+      return 0;
+    }
+    if (!ret) ret = check_frame_limits(ictx, frame);
+    if (ret < 0) LPMS_ERR_RETURN("Video frame rejected");
+  }
+  if (ret < 0 && ret != AVERROR(EAGAIN) && !tolerate_decode_error(ictx, pkt, ret)) {
+    return 0;
+  }
   if (ret == AVERROR(EAGAIN)) {
     // This is not really an error. It may be that packet just fed into
     // the decoder may be not enough to complete decoding. Upper level will
@@ -829,6 +911,7 @@ int transcode(struct transcode_thread *h,
         if (ipkt->pts == AV_NOPTS_VALUE) continue;
 
         if (ist->index == ictx->ai) {
+          if (octx->clip_closed) continue;
           if (!octx->clip_audio_start_pts_found) {
             octx->clip_audio_start_pts = ipkt->pts;
             octx->clip_audio_start_pts_found = 1;
@@ -847,7 +930,7 @@ int transcode(struct transcode_thread *h,
 
         pkt = av_packet_clone(ipkt);
         if (!pkt) LPMS_ERR(transcode_cleanup, "Error allocating packet for copy");
-        if (octx->clip_from && ist->index == ictx->ai) {
+        if (octx->clip_from && LPMS_CLIP_RELATIVE == octx->clip_mode && ist->index == ictx->ai) {
           pkt->pts -= octx->clip_audio_from_pts + octx->clip_audio_start_pts;
         }
         ret = mux(pkt, ist->time_base, octx, ost);
@@ -894,7 +977,7 @@ transcode_cleanup:
 
 // MA: this should probably be merged with transcode_init, as it basically is a
 // part of initialization
-int lpms_transcode(input_params *inp, output_params *params,
+static int transcode_session(input_params *inp, output_params *params,
   output_results *results, int nb_outputs, output_results *decoded_results, int use_new)
 {
   int ret = 0;
@@ -912,6 +995,9 @@ int lpms_transcode(input_params *inp, output_params *params,
       if (!needs_decoder(params[i].video.name)) h->ictx.dv = ++decode_v == nb_outputs;
       if (!needs_decoder(params[i].audio.name)) h->ictx.da = ++decode_a == nb_outputs;
     }
+    // outputs copying audio now may need it decoded in later segments, which
+    // new and reopened HW outputs only encode if the decoder is open
+    if (inp->decode_audio) h->ictx.da = 0;
 
     h->nb_outputs = nb_outputs;
 
@@ -969,22 +1055,53 @@ int lpms_transcode(input_params *inp, output_params *params,
   return ret;
 }
 
+int lpms_transcode(input_params *inp, output_params *params,
+  output_results *results, int nb_outputs, output_results *decoded_results, int use_new)
+{
+  int ret;
+  log_session = inp->session;
+  ret = transcode_session(inp, params, results, nb_outputs, decoded_results, use_new);
+  log_session = NULL;
+  struct input_ctx *ictx = &inp->handle->ictx;
+  decoded_results->corrupt_packets = ictx->corrupt_packets;
+  decoded_results->corrupt_frames = ictx->corrupt_frames;
+  decoded_results->dropped_frames = ictx->dropped_frames;
+  decoded_results->nb_corruptions = ictx->nb_corruptions;
+  memcpy(decoded_results->corruptions, ictx->corruptions, ictx->nb_corruptions * sizeof(int64_t));
+  decoded_results->nb_discontinuities = ictx->nb_discontinuities;
+  memcpy(decoded_results->discontinuities, ictx->discontinuities,
+    ictx->nb_discontinuities * sizeof(discontinuity_info));
+  return ret;
+}
+
 int lpms_transcode_reopen_demux(input_params *inp) {
+  int ret;
+  log_session = inp->session;
   free_input(&inp->handle->ictx);
-  return open_input(inp, &inp->handle->ictx);
+  ret = open_input(inp, &inp->handle->ictx);
+  log_session = NULL;
+  return ret;
 }
 
-struct transcode_thread* lpms_transcode_new() {
-  struct transcode_thread *h = malloc(sizeof (struct transcode_thread));
-  if (!h) return NULL;
-  memset(h, 0, sizeof *h);
+// Set up the state a session starts from, in a zeroed input context
+static void init_input_state(struct input_ctx *ictx)
+{
   // initialize video stream pixel format.
-  h->ictx.last_format = AV_PIX_FMT_NONE;
+  ictx->last_format = AV_PIX_FMT_NONE;
   // keep track of last dts in each stream.
   // used while transmuxing, to skip packets with invalid dts.
   for (int i = 0; i < MAX_OUTPUT_SIZE; i++) {
-    h->ictx.last_dts[i] = -1;
+    ictx->last_dts[i] = -1;
+    // no timestamps seen yet to detect discontinuities against
+    ictx->last_in_dts[i] = ictx->next_in_dts[i] = AV_NOPTS_VALUE;
   }
+}
+
+struct transcode_thread* lpms_transcode_new() {
+  struct transcode_thread *h = malloc(sizeof (struct transcode_thread));
+  if (!h) return NULL;
+  memset(h, 0, sizeof *h);
+  init_input_state(&h->ictx);
   return h;
 }
 
@@ -1004,11 +1121,12 @@ void lpms_transcode_stop(struct transcode_thread *handle) {
   }
 
   if (handle->dnn_filtergraph) avfilter_graph_free(&handle->dnn_filtergraph);
+  av_freep(&handle->dnn_args);
 
   free(handle);
 }
 
-static AVFilterGraph * create_dnn_filtergraph(lvpdnn_opts *dnn_opts)
+static AVFilterGraph * create_dnn_filtergraph(char *filter_args)
 {
   const AVFilter *filter = NULL;
   AVFilterContext *filter_ctx = NULL;
@@ -1016,9 +1134,6 @@ static AVFilterGraph * create_dnn_filtergraph(lvpdnn_opts *dnn_opts)
   int ret = 0;
   char errstr[1024];
   char *filter_name = "livepeer_dnn";
-  char filter_args[512];
-  snprintf(filter_args, sizeof filter_args, "model=%s:input=%s:output=%s:backend_configs=%s",
-           dnn_opts->modelpath, dnn_opts->inputname, dnn_opts->outputname, dnn_opts->backend_configs);
 
   /* allocate graph */
   graph_ctx = avfilter_graph_alloc();
@@ -1053,8 +1168,14 @@ struct transcode_thread* lpms_transcode_new_with_dnn(lvpdnn_opts *dnn_opts)
   struct transcode_thread *h = malloc(sizeof (struct transcode_thread));
   if (!h) return NULL;
   memset(h, 0, sizeof *h);
-  AVFilterGraph *filtergraph = create_dnn_filtergraph(dnn_opts);
+  init_input_state(&h->ictx);
+  char filter_args[512];
+  snprintf(filter_args, sizeof filter_args, "model=%s:input=%s:output=%s:backend_configs=%s",
+           dnn_opts->modelpath, dnn_opts->inputname, dnn_opts->outputname, dnn_opts->backend_configs);
+  h->dnn_args = av_strdup(filter_args);
+  AVFilterGraph *filtergraph = h->dnn_args ? create_dnn_filtergraph(h->dnn_args) : NULL;
   if (!filtergraph) {
+      av_freep(&h->dnn_args);
       free(h);
       h = NULL;
   } else {
@@ -1070,3 +1191,35 @@ void lpms_transcode_discontinuity(struct transcode_thread *handle) {
     handle->ictx.discontinuity[i] = 1;
   }
 }
+
+// Tear down the demuxer, decoders, filters and encoders so that they get set up
+// from scratch on the next segment, just like in a new session. Used when the
+// input configuration changes in ways the running pipeline can't adapt to.
+// The DNN filtergraph, which the DNN output takes over and frees along with
+// its filters, is created anew.
+// @return  <0: the DNN filtergraph couldn't be created =0: success
+int lpms_transcode_reinit(struct transcode_thread *handle) {
+  // not threadsafe as-is; calling function must ensure exclusivity!
+  int ret = 0;
+  if (!handle) return ret;
+  if (handle->ictx.first_pkt) av_packet_free(&handle->ictx.first_pkt);
+  free_input(&handle->ictx);
+  for (int i = 0; i < MAX_OUTPUT_SIZE; i++) {
+    free_output(&handle->outputs[i]);
+  }
+  // continuous timestamps carry on through the restart
+  int ts_session_started = handle->ictx.ts_session_started;
+  int64_t ts_end = handle->ictx.ts_end;
+  memset(&handle->ictx, 0, sizeof handle->ictx);
+  memset(handle->outputs, 0, sizeof handle->outputs);
+  handle->ictx.ts_session_started = ts_session_started;
+  handle->ictx.ts_end = ts_end;
+  init_input_state(&handle->ictx);
+  handle->nb_outputs = 0;
+  handle->initialized = 0;
+  if (handle->dnn_args && !handle->dnn_filtergraph) {
+    handle->dnn_filtergraph = create_dnn_filtergraph(handle->dnn_args);
+    if (!handle->dnn_filtergraph) ret = AVERROR(EINVAL);
+  }
+  return ret;
+}
@@ -17,6 +17,8 @@ extern const int lpms_ERR_PACKET_ONLY;
 extern const int lpms_ERR_FILTER_FLUSHED;
 extern const int lpms_ERR_OUTPUTS;
 extern const int lpms_ERR_UNRECOVERABLE;
+extern const int lpms_ERR_INPUT_LIMITS;
+extern const int lpms_ERR_INPUT_CORRUPT;
 
 struct transcode_thread;
 
@@ -25,11 +27,35 @@ typedef struct {
     AVDictionary *opts;
 } component_opts;
 
+// How the from/to clip window of an output is measured
+enum lpms_clip_mode {
+  // from the first frame of each track in the session; timestamps are rebased
+  LPMS_CLIP_RELATIVE = 0,
+  // in input timestamps; timestamps are kept
+  LPMS_CLIP_ABSOLUTE,
+  // from the first frame of each track in the segment; timestamps are kept
+  LPMS_CLIP_ANCHORED,
+};
+
+// Where keyframes of the encoded video outputs are forced, so that they line
+// up across the outputs of a call
+enum lpms_kf_align {
+  LPMS_KF_ALIGN_NONE = 0,
+  // on the first frame of every kf_interval ms of the input timeline
+  LPMS_KF_ALIGN_TIME,
+  // on the first frame at or after each keyframe of the input
+  LPMS_KF_ALIGN_SOURCE,
+};
+
 typedef struct {
   char *fname;
   char *vfilters;
   char *sfilters;
   int w, h, bitrate, gop_time, from, to;
+  int clip_mode;
+  // the clip window ended before this input; nothing is emitted
+  int clip_closed;
+  int kf_align, kf_interval;
   AVRational fps;
   int is_dnn;
   char *xcoderParams;
@@ -38,6 +64,45 @@ typedef struct {
   component_opts video;
 } output_params;
 
+// Limits on the input; exceeding any aborts the transcode with
+// lpms_ERR_INPUT_LIMITS. Zero for no limit.
+typedef struct {
+  // resolution, in either orientation
+  int max_width, max_height;
+  // decoded video frames, and ms between the first and last, per segment
+  int max_frames;
+  int max_duration;
+  // video frames out of the filters for a single decoded frame
+  int max_dup_frames;
+  // ms between consecutive packets of a stream
+  int max_pts_gap;
+  // bytes
+  int max_packet_size;
+  // bytes of frames the video decoder may hold, estimated
+  int64_t max_frame_memory;
+} input_limits;
+
+// Handling of corrupt input
+enum lpms_error_tolerance {
+  LPMS_ERROR_TOLERANCE_DEFAULT,
+  // fail with lpms_ERR_INPUT_CORRUPT
+  LPMS_ERROR_TOLERANCE_STRICT,
+  // decode past errors, concealing damage
+  LPMS_ERROR_TOLERANCE_CONCEAL,
+  // drop corrupt video frames until the next keyframe
+  LPMS_ERROR_TOLERANCE_SKIP_GOP
+};
+
+// Timestamps of the outputs
+enum lpms_ts_mode {
+  // as in the input
+  LPMS_TS_PASSTHROUGH,
+  // starting from zero in every segment
+  LPMS_TS_REBASE,
+  // starting from zero, each segment continuing where the previous ended
+  LPMS_TS_CONTINUOUS
+};
+
 typedef struct {
   char *fname;
 
@@ -56,12 +121,44 @@ typedef struct {
   component_opts video;
 
   int transmuxe;
+
+  // Session tag passed along with log messages of this transcode
+  char *session;
+
+  input_limits limits;
+
+  enum lpms_error_tolerance error_tolerance;
+
+  enum lpms_ts_mode ts_mode;
+
+  // ms a stream may jump ahead between segments before a discontinuity is
+  // assumed; jumps back always are. Zero disables detection.
+  int discontinuity_threshold;
+
+  // Decode audio even while no output encodes it, for outputs that decide
+  // between copying and encoding it anew with every segment
+  int decode_audio;
+
+  // Video frames of the stream before this input, when a stream is
+  // transcoded in parts. Frame rate filters carry on from there, picking the
+  // frames a transcode of the whole stream would.
+  int64_t frame_offset;
 } input_params;
 
 #define MAX_CLASSIFY_SIZE 10
 #define LVPDNN_FILTER_NAME "lvpdnn"
 #define LVPDNN_FILTER_META "lavfi.lvpdnn.text"
 #define MAX_OUTPUT_SIZE 10
+#define MAX_KEYFRAMES 1024
+#define MAX_CORRUPTIONS 64
+
+// A discontinuity found at the start of a segment, in AV_TIME_BASE units
+typedef struct {
+  int stream_index;
+  // first input pts of the stream in the segment, and how far it is from
+  // where the stream ended in the previous segment
+  int64_t pts, gap;
+} discontinuity_info;
 
 typedef struct {
     char *modelpath;
@@ -75,6 +172,18 @@ typedef struct {
     int64_t pixels;
     //for scene classification  
     float probs[MAX_CLASSIFY_SIZE];//probability
+    // pts of the video keyframes written, in AV_TIME_BASE units; only the
+    // first MAX_KEYFRAMES are kept but all are counted
+    int64_t keyframes[MAX_KEYFRAMES];
+    int nb_keyframes;
+    // corrupt input, for the decoded results: counts, and the first
+    // MAX_CORRUPTIONS pts in AV_TIME_BASE units
+    int corrupt_packets, corrupt_frames, dropped_frames;
+    int64_t corruptions[MAX_CORRUPTIONS];
+    int nb_corruptions;
+    // detected discontinuities, for the decoded results; at most one per stream
+    discontinuity_info discontinuities[MAX_OUTPUT_SIZE];
+    int nb_discontinuities;
 } output_results;
 
 enum LPMSLogLevel {
@@ -90,11 +199,13 @@ enum LPMSLogLevel {
 };
 
 void lpms_init(enum LPMSLogLevel max_level);
+void lpms_set_log_callback(int enabled);
 int lpms_transcode(input_params *inp, output_params *params, output_results *results, int nb_outputs, output_results *decoded_results, int use_new);
 int lpms_transcode_reopen_demux(input_params *inp);
 struct transcode_thread* lpms_transcode_new();
 struct transcode_thread* lpms_transcode_new_with_dnn(lvpdnn_opts *dnn_opts);
 void lpms_transcode_stop(struct transcode_thread* handle);
 void lpms_transcode_discontinuity(struct transcode_thread *handle);
+int lpms_transcode_reinit(struct transcode_thread *handle);
 
 #endif // _LPMS_TRANSCODER_H_
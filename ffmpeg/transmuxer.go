@@ -0,0 +1,144 @@
+package ffmpeg
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrTransmuxerClosed = errors.New("TransmuxerClosed")
+
+// MP4Layout selects how a Transmuxer lays out its MP4 output
+type MP4Layout int
+
+const (
+	// Fragmented MP4, playable while it is still being written
+	MP4Fragmented MP4Layout = iota
+	// Regular MP4 with the index moved to the front once closed
+	MP4Faststart
+)
+
+var mp4LayoutMovflags = map[MP4Layout]string{
+	MP4Fragmented: "frag_keyframe+negative_cts_offsets+omit_tfhd_offset+disable_chpl+default_base_moof",
+	MP4Faststart:  "faststart+negative_cts_offsets+disable_chpl",
+}
+
+// Gap between segments above which a discontinuity is assumed, if unset
+const DefaultTransmuxGapThreshold = time.Second
+
+type TransmuxerOptions struct {
+	Oname  string
+	Layout MP4Layout
+	// Jumps back in timestamps between consecutive segments, and gaps larger
+	// than this, are treated as discontinuities and closed up. Zero means
+	// DefaultTransmuxGapThreshold, negative disables detection.
+	GapThreshold time.Duration
+}
+
+// TransmuxSummary describes what a Transmuxer has joined
+type TransmuxSummary struct {
+	Oname    string
+	Segments int
+	// Video packets written
+	Frames int
+	// Discontinuities, whether signaled or detected
+	Discontinuities int
+	// Detected timestamp gaps, included in Discontinuities
+	Gaps     int
+	Duration time.Duration
+}
+
+// Transmuxer joins segments, such as HLS TS segments, into a single MP4
+// without re-encoding, keeping timestamps continuous across discontinuities.
+type Transmuxer struct {
+	tc      *Transcoder
+	out     []TranscodeOptions
+	opts    TransmuxerOptions
+	summary TransmuxSummary
+	// discontinuity signaled for the next segment
+	pendingDisc bool
+	closed      bool
+	mu          *sync.Mutex
+}
+
+func NewTransmuxer(opts TransmuxerOptions) *Transmuxer {
+	if opts.GapThreshold == 0 {
+		opts.GapThreshold = DefaultTransmuxGapThreshold
+	}
+	return &Transmuxer{
+		tc:   NewTranscoder(),
+		opts: opts,
+		out: []TranscodeOptions{{
+			Oname:        opts.Oname,
+			Profile:      VideoProfile{Format: FormatNone},
+			VideoEncoder: ComponentOptions{Name: "copy"},
+			AudioEncoder: ComponentOptions{Name: "copy"},
+			Muxer: ComponentOptions{
+				Name: "mp4",
+				Opts: map[string]string{"movflags": mp4LayoutMovflags[opts.Layout]},
+			},
+		}},
+		summary: TransmuxSummary{Oname: opts.Oname},
+		mu:      &sync.Mutex{},
+	}
+}
+
+// Append adds the segment to the end of the output
+func (t *Transmuxer) Append(segment string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return ErrTransmuxerClosed
+	}
+	var format MediaFormatInfo
+	if !strings.HasPrefix(strings.ToLower(segment), "pipe:") {
+		_, f, err := GetCodecInfo(segment)
+		if err != nil {
+			return err
+		}
+		format = f
+	}
+	in := &TranscodeOptionsIn{Fname: segment, Transmuxing: true, DiscontinuityThreshold: t.opts.GapThreshold}
+	res, err := t.tc.Transcode(in, t.out)
+	if err != nil {
+		return err
+	}
+	t.pendingDisc = false
+	if len(res.Discontinuities) > 0 {
+		t.summary.Discontinuities++
+		t.summary.Gaps++
+	}
+	t.summary.Segments++
+	t.summary.Frames += res.Decoded.Frames
+	t.summary.Duration += format.Duration
+	return nil
+}
+
+// Discontinuity signals that the next segment does not continue the
+// timestamps of the previous one
+func (t *Transmuxer) Discontinuity() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed || t.pendingDisc {
+		return
+	}
+	t.tc.Discontinuity()
+	t.pendingDisc = true
+	t.summary.Discontinuities++
+}
+
+// Close finalizes the output and returns a summary of what was joined
+func (t *Transmuxer) Close() (TransmuxSummary, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return t.summary, ErrTransmuxerClosed
+	}
+	t.closed = true
+	t.tc.StopTranscoder()
+	if t.summary.Segments == 0 {
+		return t.summary, ErrEmptyData
+	}
+	return t.summary, nil
+}
@@ -5,6 +5,9 @@ import (
 	"io"
 	"os"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestTransmuxer_Pipe(t *testing.T) {
@@ -167,3 +170,62 @@ func TestTransmuxer_Discontinuity(t *testing.T) {
   `
 	run(cmd)
 }
+
+func TestTransmuxer_Append(t *testing.T) {
+	run, dir := setupTest(t)
+	defer os.RemoveAll(dir)
+	cmd := `
+    ffmpeg -loglevel warning -i "$1"/../transcoder/test.ts -c:a copy -c:v copy -f hls test.m3u8
+  `
+	run(cmd)
+
+	for _, layout := range []MP4Layout{MP4Fragmented, MP4Faststart} {
+		// the second round jumps back in time, with or without being told so
+		for _, signal := range []bool{true, false} {
+			oname := fmt.Sprintf("%s/out-%d-%v.mp4", dir, layout, signal)
+			tm := NewTransmuxer(TransmuxerOptions{Oname: oname, Layout: layout})
+			for round := 0; round < 2; round++ {
+				if round == 1 && signal {
+					tm.Discontinuity()
+				}
+				for i := 0; i < 4; i++ {
+					require.NoError(t, tm.Append(fmt.Sprintf("%s/test%d.ts", dir, i)))
+				}
+			}
+			summary, err := tm.Close()
+			require.NoError(t, err)
+			assert.Equal(t, oname, summary.Oname)
+			assert.Equal(t, 8, summary.Segments)
+			assert.Equal(t, 960, summary.Frames)
+			assert.Equal(t, 1, summary.Discontinuities)
+			if signal {
+				assert.Equal(t, 0, summary.Gaps)
+			} else {
+				assert.Equal(t, 1, summary.Gaps)
+			}
+			assert.InDelta(t, 16, summary.Duration.Seconds(), 0.5)
+
+			_, err = tm.Close()
+			assert.Equal(t, ErrTransmuxerClosed, err)
+			assert.Equal(t, ErrTransmuxerClosed, tm.Append(dir+"/test0.ts"))
+
+			cmd = fmt.Sprintf(`
+        ffprobe -loglevel warning -select_streams v -count_frames -show_streams %s | grep nb_read_frames=960
+        ffprobe -loglevel warning -select_streams v -count_frames -show_streams -show_frames %s | grep pkt_pts=1441410
+      `, oname, oname)
+			assert.True(t, run(cmd))
+		}
+	}
+	cmd = `
+    # faststart puts the index before the media data
+    ffprobe -loglevel trace out-1-true.mp4 2>&1 | grep -m1 -o "type:'\(moov\|mdat\)'" | grep moov
+    # fragmented output is made of fragments
+    ffprobe -loglevel trace out-0-true.mp4 2>&1 | grep -q "type:'moof'"
+  `
+	assert.True(t, run(cmd))
+
+	// nothing appended
+	tm := NewTransmuxer(TransmuxerOptions{Oname: dir + "/empty.mp4"})
+	_, err := tm.Close()
+	assert.Equal(t, ErrEmptyData, err)
+}
@@ -0,0 +1,184 @@
+package ffmpeg
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var ErrTranscoderLimits = errors.New("TranscoderEncoderLimits")
+
+// EncoderLimits describes the output an encoder accepts
+type EncoderLimits struct {
+	Size CodingSizeLimit
+	// The dimension given by the profile must be a multiple of this; the
+	// other one is derived and always even
+	Alignment int
+	// Frames per second; zero for no limit
+	MaxFramerate float64
+	// Highest level, as level_idc, bounding frame size and sample rate;
+	// zero if the codec has no levels
+	MaxLevel int
+	// Bits per second; zero MaxBitrate for no limit
+	MinBitrate, MaxBitrate int
+}
+
+// Limits of the encoders in FfEncoderLookup, by encoder name
+var EncoderLimitsLookup = map[string]EncoderLimits{
+	"libx264":     {Size: CodingSizeLimit{2, 2, 16384, 16384}, Alignment: 2, MaxLevel: 62, MinBitrate: 1000, MaxBitrate: 800000000},
+	"libx265":     {Size: CodingSizeLimit{8, 8, 16384, 16384}, Alignment: 2, MaxLevel: 186, MinBitrate: 1000, MaxBitrate: 800000000},
+	"libvpx":      {Size: CodingSizeLimit{2, 2, 16383, 16383}, Alignment: 2, MinBitrate: 1000},
+	"libvpx-vp9":  {Size: CodingSizeLimit{2, 2, 16384, 16384}, Alignment: 2, MaxLevel: 62, MinBitrate: 1000, MaxBitrate: 480000000},
+	"h264_nvenc":  {Size: nvidiaCodecSizeLimts[H264], Alignment: 1, MaxLevel: 62, MinBitrate: 1000, MaxBitrate: 800000000},
+	"hevc_nvenc":  {Size: nvidiaCodecSizeLimts[H265], Alignment: 1, MaxLevel: 186, MinBitrate: 1000, MaxBitrate: 800000000},
+	"h264_ni_enc": {Size: CodingSizeLimit{32, 32, 8192, 8192}, Alignment: 2, MaxLevel: 51, MinBitrate: 1000, MaxBitrate: 700000000},
+	"h265_ni_enc": {Size: CodingSizeLimit{32, 32, 8192, 8192}, Alignment: 2, MaxLevel: 153, MinBitrate: 1000, MaxBitrate: 700000000},
+}
+
+var levelTables = map[VideoCodec][]codecLevel{
+	H264: h264Levels,
+	H265: hevcLevels,
+	VP9:  vp9Levels,
+}
+
+// LimitViolation is an output setting its encoder doesn't accept
+type LimitViolation struct {
+	// Index of the output
+	Output int
+	// Setting at fault: Resolution, Framerate, Level or Bitrate
+	Field   string
+	Value   string
+	Allowed string
+}
+
+func (v LimitViolation) Error() string {
+	return fmt.Sprintf("output %d: %s %s not within %s", v.Output, v.Field, v.Value, v.Allowed)
+}
+
+// ValidationError lists every LimitViolation of a set of outputs
+type ValidationError struct {
+	Violations []LimitViolation
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		msgs[i] = v.Error()
+	}
+	return fmt.Sprintf("%s: %s", ErrTranscoderLimits, strings.Join(msgs, "; "))
+}
+
+func (e *ValidationError) Unwrap() error {
+	return ErrTranscoderLimits
+}
+
+// ValidateTranscodeOptions checks the video encoding outputs against the
+// limits of their encoders, returning a *ValidationError with all violations
+// found. Output sizes are worked out for the input format, if known, the way
+// the scaler derives them. Outputs copying or dropping video, running
+// detectors or using encoders without known limits are not checked, nor are
+// those without a valid resolution, which fail on their own.
+func ValidateTranscodeOptions(ps []TranscodeOptions, format MediaFormatInfo) error {
+	var violations []LimitViolation
+	for i, p := range ps {
+		violations = append(violations, validateOutput(i, p, format)...)
+	}
+	if len(violations) > 0 {
+		return &ValidationError{Violations: violations}
+	}
+	return nil
+}
+
+func validateOutput(i int, p TranscodeOptions, format MediaFormatInfo) []LimitViolation {
+	name := p.VideoEncoder.Name
+	if name == "copy" || name == "drop" || p.Detector != nil {
+		return nil
+	}
+	if name == "" {
+		name = FfEncoderLookup[p.Accel][p.Profile.Encoder]
+	}
+	limits, ok := EncoderLimitsLookup[name]
+	if !ok {
+		return nil
+	}
+	w, h, err := VideoProfileResolution(p.Profile)
+	if err != nil || w <= 0 || h <= 0 {
+		return nil
+	}
+	var violations []LimitViolation
+	violate := func(field, value, allowed string) {
+		violations = append(violations, LimitViolation{Output: i, Field: field, Value: value, Allowed: allowed})
+	}
+
+	given := w
+	if format.Width > 0 && format.Height > 0 && format.Width < format.Height {
+		given = h
+	}
+	w, h = scaledResolution(w, h, format)
+	resolution := fmt.Sprintf("%dx%d", w, h)
+	l := limits.Size
+	if w < l.WidthMin || w > l.WidthMax || h < l.HeightMin || h > l.HeightMax {
+		violate("Resolution", resolution, fmt.Sprintf("%dx%d-%dx%d", l.WidthMin, l.HeightMin, l.WidthMax, l.HeightMax))
+	}
+	if limits.Alignment > 1 && given%limits.Alignment != 0 {
+		violate("Resolution", resolution, fmt.Sprintf("multiples of %d", limits.Alignment))
+	}
+
+	fps := profileFramerate(p.Profile)
+	if fps == 0 || p.Profile.FramerateMode == FramerateMax && format.Framerate > 0 && format.Framerate < fps {
+		fps = format.Framerate
+	}
+	if limits.MaxFramerate > 0 && fps > limits.MaxFramerate {
+		violate("Framerate", strconv.FormatFloat(fps, 'f', -1, 64), fmt.Sprintf("%v fps", limits.MaxFramerate))
+	}
+	maxLevel := limits.MaxLevel
+	if p.Profile.Level > 0 && p.Profile.Encoder == H264 && p.Profile.Level < maxLevel {
+		maxLevel = p.Profile.Level
+	}
+	if levels, ok := levelTables[p.Profile.Encoder]; ok && maxLevel > 0 {
+		frameSize := int64(w) * int64(h)
+		if p.Profile.Encoder == H264 {
+			frameSize = int64((w+15)/16) * int64((h+15)/16)
+		}
+		for _, level := range levels {
+			if level.idc != maxLevel {
+				continue
+			}
+			// an unknown frame rate only bounds the frame size
+			if frameSize > level.frameSize || float64(frameSize)*fps > float64(level.rate) {
+				violate("Level", fmt.Sprintf("%s at %v fps", resolution, fps), fmt.Sprintf("level_idc %d", maxLevel))
+			}
+		}
+	}
+
+	bitrate, err := strconv.Atoi(strings.Replace(p.Profile.Bitrate, "k", "000", 1))
+	if err != nil || bitrate < limits.MinBitrate || limits.MaxBitrate > 0 && bitrate > limits.MaxBitrate {
+		allowed := fmt.Sprintf("%d-%d bps", limits.MinBitrate, limits.MaxBitrate)
+		if limits.MaxBitrate == 0 {
+			allowed = fmt.Sprintf("at least %d bps", limits.MinBitrate)
+		}
+		violate("Bitrate", strconv.Quote(p.Profile.Bitrate), allowed)
+	}
+	return violations
+}
+
+// scaledResolution is the output resolution for a profile resolution of w x h.
+// The scaler keeps the profile width for landscape input and the height for
+// portrait, deriving the other from the input aspect ratio.
+func scaledResolution(w, h int, format MediaFormatInfo) (int, int) {
+	if format.Width <= 0 || format.Height <= 0 {
+		return w, h
+	}
+	if format.Width >= format.Height {
+		return w, evenScaled(w, format.Height, format.Width)
+	}
+	return evenScaled(h, format.Width, format.Height), h
+}
+
+// evenScaled scales size by num/den, rounding to an even number like the
+// scaler's -2 does
+func evenScaled(size, num, den int) int {
+	return int(math.Round(float64(size)*float64(num)/float64(den)/2)) * 2
+}
@@ -0,0 +1,76 @@
+package ffmpeg
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateTranscodeOptions(t *testing.T) {
+	landscape := MediaFormatInfo{Width: 1280, Height: 720, Framerate: 30}
+	out := func(p VideoProfile) TranscodeOptions {
+		return TranscodeOptions{Profile: p}
+	}
+
+	// sample profiles are fine
+	ps := []TranscodeOptions{out(P720p30fps16x9), out(P360p30fps16x9), out(P144p30fps16x9)}
+	require.NoError(t, ValidateTranscodeOptions(ps, landscape))
+	// and so are outputs that don't encode video, or without known limits
+	ps = []TranscodeOptions{
+		{VideoEncoder: ComponentOptions{Name: "copy"}},
+		{VideoEncoder: ComponentOptions{Name: "drop"}},
+		{Profile: VideoProfile{Resolution: "853x480"}, VideoEncoder: ComponentOptions{Name: "mpeg2video"}},
+	}
+	require.NoError(t, ValidateTranscodeOptions(ps, landscape))
+
+	// all violations are reported at once
+	odd := P360p30fps16x9
+	odd.Resolution = "639x360"
+	zero := P240p30fps16x9
+	zero.Bitrate = "0"
+	huge := VideoProfile{Resolution: "16384x16384", Bitrate: "6000k", Framerate: 30}
+	hevc := P144p30fps16x9
+	hevc.Encoder = H265
+	ps = []TranscodeOptions{out(odd), out(zero), out(huge), out(hevc)}
+	err := ValidateTranscodeOptions(ps, MediaFormatInfo{})
+	require.True(t, errors.Is(err, ErrTranscoderLimits))
+	var verr *ValidationError
+	require.True(t, errors.As(err, &verr))
+	fields := map[int][]string{}
+	for _, v := range verr.Violations {
+		fields[v.Output] = append(fields[v.Output], v.Field)
+	}
+	assert.Equal(t, map[int][]string{
+		0: {"Resolution"},
+		1: {"Bitrate"},
+		2: {"Level"},
+	}, fields)
+
+	// an explicit level bounds the frame size and rate too
+	leveled := out(P720p30fps16x9)
+	leveled.Profile.Level = 30
+	err = ValidateTranscodeOptions([]TranscodeOptions{leveled}, landscape)
+	require.True(t, errors.As(err, &verr))
+	assert.Equal(t, "Level", verr.Violations[0].Field)
+	leveled.Profile.Level = 31
+	require.NoError(t, ValidateTranscodeOptions([]TranscodeOptions{leveled}, landscape))
+
+	// the derived dimension follows the input, so only the given one counts
+	portrait := MediaFormatInfo{Width: 720, Height: 1280, Framerate: 30}
+	p := P144p30fps16x9
+	p.Resolution = "145x144"
+	require.NoError(t, ValidateTranscodeOptions([]TranscodeOptions{out(p)}, portrait))
+	err = ValidateTranscodeOptions([]TranscodeOptions{out(p)}, landscape)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "output 0: Resolution 145x82 not within multiples of 2")
+
+	// nvenc minimum sizes
+	nv := out(P144p30fps16x9)
+	nv.Accel = Nvidia
+	nv.Profile.Resolution = "100x56"
+	err = ValidateTranscodeOptions([]TranscodeOptions{nv}, landscape)
+	require.True(t, errors.As(err, &verr))
+	assert.Equal(t, "Resolution", verr.Violations[0].Field)
+}
@@ -3,6 +3,7 @@ package ffmpeg
 import (
 	"encoding/json"
 	"fmt"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
@@ -13,6 +14,7 @@ import (
 
 var ErrProfName = fmt.Errorf("unknown VideoProfile profile name")
 var ErrCodecName = fmt.Errorf("unknown codec name")
+var ErrAccelName = fmt.Errorf("unknown acceleration name")
 
 type Format int
 
@@ -22,6 +24,12 @@ const (
 	FormatMP4
 )
 
+var FormatLookup = map[string]Format{
+	"":       FormatNone,
+	"mpegts": FormatMPEGTS,
+	"mp4":    FormatMP4,
+}
+
 type Profile int
 
 const (
@@ -30,6 +38,8 @@ const (
 	ProfileH264Main
 	ProfileH264High
 	ProfileH264ConstrainedHigh
+	ProfileH265Main
+	ProfileH265Main10
 )
 
 var EncoderProfileLookup = map[string]Profile{
@@ -39,6 +49,8 @@ var EncoderProfileLookup = map[string]Profile{
 	"h264main":            ProfileH264Main,
 	"h264high":            ProfileH264High,
 	"h264constrainedhigh": ProfileH264ConstrainedHigh,
+	"hevcmain":            ProfileH265Main,
+	"hevcmain10":          ProfileH265Main10,
 }
 
 // For additional "special" GOP values
@@ -50,6 +62,22 @@ const (
 	GOPInvalid = -2
 )
 
+// FramerateMode selects how a non-zero VideoProfile.Framerate is applied
+type FramerateMode int
+
+const (
+	// Output exactly Framerate, duplicating or dropping frames as needed
+	FramerateFixed FramerateMode = iota
+	// Output at most Framerate; sources at a lower rate keep their cadence
+	FramerateMax
+)
+
+var FramerateModeLookup = map[string]FramerateMode{
+	"":      FramerateFixed,
+	"fixed": FramerateFixed,
+	"max":   FramerateMax,
+}
+
 type VideoCodec int
 
 const (
@@ -87,14 +115,41 @@ type VideoProfile struct {
 	Bitrate      string
 	Framerate    uint
 	FramerateDen uint
-	Resolution   string
-	AspectRatio  string
-	Format       Format
-	Profile      Profile
-	GOP          time.Duration
-	Encoder      VideoCodec
-	ColorDepth   ColorDepthBits
-	ChromaFormat ChromaSubsampling
+	// Zero Framerate passes the source frame rate through regardless of this
+	FramerateMode FramerateMode
+	Resolution    string
+	AspectRatio   string
+	Format        Format
+	Profile       Profile
+	GOP           time.Duration
+	Encoder       VideoCodec
+	ColorDepth    ColorDepthBits
+	ChromaFormat  ChromaSubsampling
+	Audio         AudioProfile
+	// H.264 level_idc, as in 41 for level 4.1; zero leaves it to the encoder
+	Level int
+	// Acceleration preferred for encoding, for callers to honour when
+	// setting up TranscodeOptions
+	Accel Acceleration
+	// Options passed to the video encoder as is, on top of those derived
+	// from the profile, URL query encoded to keep profiles comparable;
+	// see EncoderOptsMap
+	EncoderOpts string
+	// Remux the source video into Format rather than encoding it. Resolution
+	// and Bitrate, if set, cap the source: a source exceeding either is
+	// encoded with the profile instead, at its own resolution if that is
+	// within the cap. Sources the container can't hold are encoded too.
+	// The first segment of a session decides, until the session is rebuilt
+	// for a significant ConfigChange.
+	Passthrough bool
+}
+
+// AudioProfile sets up the audio encoding of outputs that don't set their
+// AudioEncoder name or options. Zero values keep the defaults.
+type AudioProfile struct {
+	// FFmpeg encoder name
+	Codec   string
+	Bitrate string
 }
 
 //Some sample video profiles
@@ -145,8 +200,10 @@ var ProfileParameters = map[Profile]string{
 	ProfileNone:                "",
 	ProfileH264Baseline:        "baseline",
 	ProfileH264Main:            "main",
-	ProfileH264High:            "high422",
-	ProfileH264ConstrainedHigh: "high422",
+	ProfileH264High:            "high",
+	ProfileH264ConstrainedHigh: "high",
+	ProfileH265Main:            "main",
+	ProfileH265Main10:          "main10",
 }
 
 func VideoProfileResolution(p VideoProfile) (int, int, error) {
@@ -178,6 +235,234 @@ func VideoProfileToVariantParams(p VideoProfile) m3u8.VariantParams {
 	return m3u8.VariantParams{Bandwidth: uint32(b), Resolution: r}
 }
 
+// Frame rate assumed for codec levels when the profile keeps the source rate
+const assumedLevelFramerate = 60
+
+type codecLevel struct {
+	idc       int
+	frameSize int64 // max frame size, in macroblocks for H.264, luma samples otherwise
+	rate      int64 // max frame size * frames per second, in the same units
+}
+
+// H.264 levels from Table A-1
+var h264Levels = []codecLevel{
+	{10, 99, 1485}, {11, 396, 3000}, {12, 396, 6000}, {13, 396, 11880},
+	{20, 396, 11880}, {21, 792, 19800}, {22, 1620, 20250},
+	{30, 1620, 40500}, {31, 3600, 108000}, {32, 5120, 216000},
+	{40, 8192, 245760}, {41, 8192, 245760}, {42, 8704, 522240},
+	{50, 22080, 589824}, {51, 36864, 983040}, {52, 36864, 2073600},
+	{60, 139264, 4177920}, {61, 139264, 8355840}, {62, 139264, 16711680},
+}
+
+// HEVC levels from Table A.8, with level_idc being 30 times the level
+var hevcLevels = []codecLevel{
+	{30, 36864, 552960}, {60, 122880, 3686400}, {63, 245760, 7372800},
+	{90, 552960, 16588800}, {93, 983040, 33177600},
+	{120, 2228224, 66846720}, {123, 2228224, 133693440},
+	{150, 8912896, 267386880}, {153, 8912896, 534773760}, {156, 8912896, 1069547520},
+	{180, 35651584, 1069547520}, {183, 35651584, 2139095040}, {186, 35651584, 4278190080},
+}
+
+// VP9 levels from the VP9 codec level definitions
+var vp9Levels = []codecLevel{
+	{10, 36864, 829440}, {11, 73728, 2764800}, {20, 122880, 4608000},
+	{21, 245760, 9216000}, {30, 552960, 20736000}, {31, 983040, 36864000},
+	{40, 2228224, 83558400}, {41, 2228224, 160432128},
+	{50, 8912896, 311951360}, {51, 8912896, 588251136}, {52, 8912896, 1176502272},
+	{60, 35651584, 1176502272}, {61, 35651584, 2353004544}, {62, 35651584, 4706009088},
+}
+
+// lowest level that fits the frame size and rate, or the highest one
+func findCodecLevel(levels []codecLevel, frameSize int64, fps float64) int {
+	for _, l := range levels {
+		if frameSize <= l.frameSize && float64(frameSize)*fps <= float64(l.rate) {
+			return l.idc
+		}
+	}
+	return levels[len(levels)-1].idc
+}
+
+// VideoProfileCodecs returns the RFC 6381 codec string of the video encoded
+// with the given profile, as used in the HLS CODECS attribute. The level is
+// derived from the resolution and frame rate. Returns an empty string if the
+// resolution is invalid.
+func VideoProfileCodecs(p VideoProfile) string {
+	level := 0
+	if p.Encoder == H264 {
+		level = p.Level
+	}
+	return videoCodecs(p, level)
+}
+
+// videoCodecs is VideoProfileCodecs at the given level_idc, derived if zero
+func videoCodecs(p VideoProfile, level int) string {
+	p.Resolution = strings.Replace(p.Resolution, ":", "x", 1)
+	w, h, err := VideoProfileResolution(p)
+	if err != nil || w <= 0 || h <= 0 {
+		return ""
+	}
+	fps := float64(assumedLevelFramerate)
+	if p.Framerate > 0 {
+		fps = float64(p.Framerate)
+		if p.FramerateDen > 0 {
+			fps /= float64(p.FramerateDen)
+		}
+	}
+	samples := int64(w) * int64(h)
+	switch p.Encoder {
+	case H264:
+		if level == 0 {
+			mbs := int64((w+15)/16) * int64((h+15)/16)
+			level = findCodecLevel(h264Levels, mbs, fps)
+		}
+		switch p.Profile {
+		case ProfileH264Baseline:
+			// libx264 produces constrained baseline
+			return fmt.Sprintf("avc1.42E0%02X", level)
+		case ProfileH264Main:
+			return fmt.Sprintf("avc1.4D40%02X", level)
+		default:
+			return fmt.Sprintf("avc1.6400%02X", level)
+		}
+	case H265:
+		if level == 0 {
+			level = findCodecLevel(hevcLevels, samples, fps)
+		}
+		if p.ColorDepth > ColorDepth8Bit || p.Profile == ProfileH265Main10 {
+			return fmt.Sprintf("hvc1.2.4.L%d.B0", level)
+		}
+		return fmt.Sprintf("hvc1.1.6.L%d.B0", level)
+	case VP8:
+		return "vp8"
+	case VP9:
+		if level == 0 {
+			level = findCodecLevel(vp9Levels, samples, fps)
+		}
+		depth := 8
+		if p.ColorDepth > ColorDepth8Bit {
+			depth = 10
+		}
+		return fmt.Sprintf("vp09.00.%d.%02d", level, depth)
+	}
+	return ""
+}
+
+// RFC 6381 codec strings of audio, by FFmpeg codec name
+var audioCodecStrings = map[string]string{
+	"aac":  "mp4a.40.2",
+	"mp3":  "mp4a.40.34",
+	"ac3":  "ac-3",
+	"eac3": "ec-3",
+	"opus": "opus",
+	"flac": "fLaC",
+}
+
+// VariantCodecs returns the HLS CODECS attribute of a rendition encoded with
+// the given profile and carrying audio of the given FFmpeg codec, such as the
+// Acodec of its MediaFormatInfo; empty acodec for video only renditions.
+// CODECS has to list every format in the rendition, so this returns an empty
+// string, leaving the attribute out, if either codec is unknown.
+func VariantCodecs(p VideoProfile, acodec string) string {
+	return withAudioCodec(VideoProfileCodecs(p), acodec)
+}
+
+// withAudioCodec appends the codec string of acodec to that of the video
+func withAudioCodec(codecs, acodec string) string {
+	if codecs == "" || acodec == "" {
+		return codecs
+	}
+	audio, ok := audioCodecStrings[acodec]
+	if !ok {
+		return ""
+	}
+	return codecs + "," + audio
+}
+
+// FormatCodecs returns the HLS CODECS attribute of an encoded rendition from
+// what GetCodecInfo finds in it: the codecs, and the profile and level the
+// video stream signals rather than those expected of the VideoProfile it was
+// encoded with. Empty if either codec is unknown, as with VariantCodecs.
+func FormatCodecs(f MediaFormatInfo) string {
+	codec, ok := FfmpegNameToVideoCodec[f.Vcodec]
+	if !ok {
+		return ""
+	}
+	p := VideoProfile{Resolution: fmt.Sprintf("%dx%d", f.Width, f.Height), Encoder: codec, Profile: f.Profile}
+	if _, depth, err := f.PixFormat.Properties(); err == nil {
+		p.ColorDepth = depth
+	}
+	if f.Framerate > 0 {
+		// only used if the level is unknown
+		p.Framerate, p.FramerateDen = uint(f.Framerate*1000+0.5), 1000
+	}
+	return withAudioCodec(videoCodecs(p, f.Level), f.Acodec)
+}
+
+// ValidateCodecProfile checks that the codec profile and level of p are known
+// and fit its encoder and color depth
+func ValidateCodecProfile(p VideoProfile) error {
+	switch p.Profile {
+	case ProfileNone:
+	case ProfileH264Baseline, ProfileH264Main, ProfileH264High, ProfileH264ConstrainedHigh:
+		if p.Encoder != H264 {
+			return fmt.Errorf("%w: H.264 profile for %s", ErrTranscoderPrf, VideoCodecName[p.Encoder])
+		}
+		if p.ColorDepth > ColorDepth8Bit {
+			return fmt.Errorf("%w: H.264 profiles are 8-bit only", ErrTranscoderPrf)
+		}
+	case ProfileH265Main, ProfileH265Main10:
+		if p.Encoder != H265 {
+			return fmt.Errorf("%w: HEVC profile for %s", ErrTranscoderPrf, VideoCodecName[p.Encoder])
+		}
+		maxDepth := ColorDepth8Bit
+		if p.Profile == ProfileH265Main10 {
+			maxDepth = ColorDepth10Bit
+		}
+		if p.ColorDepth > maxDepth {
+			return fmt.Errorf("%w: %d-bit color with HEVC %s", ErrTranscoderPrf, p.ColorDepth+8, ProfileParameters[p.Profile])
+		}
+	default:
+		return ErrTranscoderPrf
+	}
+	if p.Level != 0 {
+		if p.Encoder != H264 {
+			return fmt.Errorf("%w: levels are only set for H.264", ErrTranscoderPrf)
+		}
+		if !isH264Level(p.Level) {
+			return fmt.Errorf("%w: unknown H.264 level_idc %d", ErrTranscoderPrf, p.Level)
+		}
+	}
+	return nil
+}
+
+func isH264Level(idc int) bool {
+	for _, l := range h264Levels {
+		if l.idc == idc {
+			return true
+		}
+	}
+	return false
+}
+
+// H264LevelName formats an H.264 level_idc the way encoders take it, as in 4.1
+func H264LevelName(idc int) string {
+	return fmt.Sprintf("%d.%d", idc/10, idc%10)
+}
+
+// H264LevelNameToValue parses a level name such as 4.1 into its level_idc
+func H264LevelNameToValue(level string) (int, error) {
+	parts := strings.SplitN(level, ".", 2)
+	major, err := strconv.Atoi(parts[0])
+	minor := 0
+	if err == nil && len(parts) == 2 {
+		minor, err = strconv.Atoi(parts[1])
+	}
+	if err != nil || minor > 9 || !isH264Level(major*10+minor) {
+		return 0, fmt.Errorf("unknown H.264 level: %s", level)
+	}
+	return major*10 + minor, nil
+}
+
 type ByName []VideoProfile
 
 func (a ByName) Len() int      { return len(a) }
@@ -212,6 +497,18 @@ func CodecNameToValue(encoder string) (VideoCodec, error) {
 	return -1, ErrCodecName
 }
 
+func AccelerationNameToValue(accel string) (Acceleration, error) {
+	if accel == "" {
+		return Software, nil
+	}
+	for a, name := range AccelerationNameLookup {
+		if strings.EqualFold(name, accel) {
+			return a, nil
+		}
+	}
+	return -1, ErrAccelName
+}
+
 func DefaultProfileName(width int, height int, bitrate int) string {
 	return fmt.Sprintf("%dx%d_%d", width, height, bitrate)
 }
@@ -223,62 +520,144 @@ type JsonProfile struct {
 	Bitrate      int               `json:"bitrate"`
 	FPS          uint              `json:"fps"`
 	FPSDen       uint              `json:"fpsDen"`
+	FPSMode      string            `json:"fpsMode"`
 	Profile      string            `json:"profile"`
 	GOP          string            `json:"gop"`
 	Encoder      string            `json:"encoder"`
 	ColorDepth   ColorDepthBits    `json:"colorDepth"`
 	ChromaFormat ChromaSubsampling `json:"chromaFormat"`
+	Level        string            `json:"level,omitempty"`
+	Format       string            `json:"format,omitempty"`
+	AspectRatio  string            `json:"aspectRatio,omitempty"`
+	Audio        *JsonAudioProfile `json:"audio,omitempty"`
+	Accel        string            `json:"accel,omitempty"`
+	EncoderOpts  map[string]string `json:"encoderOpts,omitempty"`
+	Passthrough  bool              `json:"passthrough,omitempty"`
 }
 
+type JsonAudioProfile struct {
+	Codec   string `json:"codec,omitempty"`
+	Bitrate int    `json:"bitrate,omitempty"`
+}
+
+// ParseProfilesFromJsonProfileArray converts profiles, failing on the first
+// invalid one with an error naming its index
 func ParseProfilesFromJsonProfileArray(profiles []JsonProfile) ([]VideoProfile, error) {
 	parsedProfiles := []VideoProfile{}
-	for _, profile := range profiles {
-		name := profile.Name
-		if name == "" {
-			name = "custom_" + DefaultProfileName(profile.Width, profile.Height, profile.Bitrate)
-		}
-		var gop time.Duration
-		if profile.GOP != "" {
-			if profile.GOP == "intra" {
-				gop = GOPIntraOnly
-			} else {
-				gopFloat, err := strconv.ParseFloat(profile.GOP, 64)
-				if err != nil {
-					return parsedProfiles, fmt.Errorf("cannot parse the GOP value in the transcoding options: %w", err)
-				}
-				// gopFloat is allowed to be 0
-				if gopFloat < 0.0 {
-					return parsedProfiles, fmt.Errorf("invalid gop value %f. Please set it to a positive value", gopFloat)
-				}
-				gop = time.Duration(gopFloat * float64(time.Second))
-			}
-		}
-		encodingProfile, err := EncoderProfileNameToValue(profile.Profile)
-		if err != nil {
-			return parsedProfiles, fmt.Errorf("unable to parse the H264 encoder profile: %w", err)
-		}
-		codec, err := CodecNameToValue(profile.Encoder)
+	for i, profile := range profiles {
+		prof, err := parseJsonProfile(profile)
 		if err != nil {
-			return parsedProfiles, fmt.Errorf("Unable to parse encoder profile, unknown encoder: %s %w", profile.Encoder, err)
-		}
-		prof := VideoProfile{
-			Name:         name,
-			Bitrate:      fmt.Sprint(profile.Bitrate),
-			Framerate:    profile.FPS,
-			FramerateDen: profile.FPSDen,
-			Resolution:   fmt.Sprintf("%dx%d", profile.Width, profile.Height),
-			Profile:      encodingProfile,
-			GOP:          gop,
-			Encoder:      codec,
-			ColorDepth:   profile.ColorDepth,
-			// profile.ChromaFormat of 0 is default ChromaSubsampling420
-			ChromaFormat: profile.ChromaFormat,
+			return parsedProfiles, fmt.Errorf("profile %d: %w", i, err)
 		}
 		parsedProfiles = append(parsedProfiles, prof)
 	}
 	return parsedProfiles, nil
 }
 
+func parseJsonProfile(profile JsonProfile) (VideoProfile, error) {
+	name := profile.Name
+	if name == "" {
+		name = "custom_" + DefaultProfileName(profile.Width, profile.Height, profile.Bitrate)
+	}
+	if profile.Width < 0 || profile.Height < 0 {
+		return VideoProfile{}, fmt.Errorf("invalid resolution %dx%d", profile.Width, profile.Height)
+	}
+	if profile.Bitrate < 0 {
+		return VideoProfile{}, fmt.Errorf("invalid bitrate %d", profile.Bitrate)
+	}
+	var gop time.Duration
+	if profile.GOP != "" {
+		if profile.GOP == "intra" {
+			gop = GOPIntraOnly
+		} else {
+			gopFloat, err := strconv.ParseFloat(profile.GOP, 64)
+			if err != nil {
+				return VideoProfile{}, fmt.Errorf("cannot parse the GOP value in the transcoding options: %w", err)
+			}
+			// gopFloat is allowed to be 0
+			if gopFloat < 0.0 {
+				return VideoProfile{}, fmt.Errorf("invalid gop value %f. Please set it to a positive value", gopFloat)
+			}
+			gop = time.Duration(gopFloat * float64(time.Second))
+		}
+	}
+	encodingProfile, err := EncoderProfileNameToValue(profile.Profile)
+	if err != nil {
+		return VideoProfile{}, fmt.Errorf("unable to parse the H264 encoder profile: %w", err)
+	}
+	codec, err := CodecNameToValue(profile.Encoder)
+	if err != nil {
+		return VideoProfile{}, fmt.Errorf("Unable to parse encoder profile, unknown encoder: %s %w", profile.Encoder, err)
+	}
+	var level int
+	if profile.Level != "" {
+		if level, err = H264LevelNameToValue(profile.Level); err != nil {
+			return VideoProfile{}, err
+		}
+	}
+	fpsMode, ok := FramerateModeLookup[strings.ToLower(profile.FPSMode)]
+	if !ok {
+		return VideoProfile{}, fmt.Errorf("unknown fps mode: %s", profile.FPSMode)
+	}
+	format, ok := FormatLookup[strings.ToLower(profile.Format)]
+	if !ok {
+		return VideoProfile{}, fmt.Errorf("unknown format: %s", profile.Format)
+	}
+	if profile.AspectRatio != "" {
+		var num, den int
+		_, err := fmt.Sscanf(profile.AspectRatio, "%d:%d", &num, &den)
+		if err != nil || num <= 0 || den <= 0 || profile.AspectRatio != fmt.Sprintf("%d:%d", num, den) {
+			return VideoProfile{}, fmt.Errorf("invalid aspect ratio: %s", profile.AspectRatio)
+		}
+	}
+	accel, err := AccelerationNameToValue(profile.Accel)
+	if err != nil {
+		return VideoProfile{}, fmt.Errorf("%w: %s", err, profile.Accel)
+	}
+	var audio AudioProfile
+	if profile.Audio != nil {
+		if profile.Audio.Bitrate < 0 {
+			return VideoProfile{}, fmt.Errorf("invalid audio bitrate %d", profile.Audio.Bitrate)
+		}
+		audio.Codec = profile.Audio.Codec
+		if profile.Audio.Bitrate > 0 {
+			audio.Bitrate = fmt.Sprint(profile.Audio.Bitrate)
+		}
+	}
+	encoderOpts := url.Values{}
+	for k, v := range profile.EncoderOpts {
+		if k == "" {
+			return VideoProfile{}, fmt.Errorf("empty encoder option name")
+		}
+		encoderOpts.Set(k, v)
+	}
+	prof := VideoProfile{
+		Name:          name,
+		Bitrate:       fmt.Sprint(profile.Bitrate),
+		Framerate:     profile.FPS,
+		FramerateDen:  profile.FPSDen,
+		FramerateMode: fpsMode,
+		Resolution:    fmt.Sprintf("%dx%d", profile.Width, profile.Height),
+		AspectRatio:   profile.AspectRatio,
+		Format:        format,
+		Profile:       encodingProfile,
+		Level:         level,
+		GOP:           gop,
+		Encoder:       codec,
+		ColorDepth:    profile.ColorDepth,
+		// profile.ChromaFormat of 0 is default ChromaSubsampling420
+		ChromaFormat: profile.ChromaFormat,
+		Audio:        audio,
+		Accel:        accel,
+		EncoderOpts:  encoderOpts.Encode(),
+		Passthrough:  profile.Passthrough,
+	}
+	if err := ValidateCodecProfile(prof); err != nil {
+		return VideoProfile{}, err
+	}
+	return prof, nil
+}
+
 func ParseProfiles(injson []byte) ([]VideoProfile, error) {
 	type jsonProfileArray struct {
 		Profiles []JsonProfile `json:"profiles"`
@@ -290,3 +669,128 @@ func ParseProfiles(injson []byte) ([]VideoProfile, error) {
 	}
 	return ParseProfilesFromJsonProfileArray(decodedJson.Profiles)
 }
+
+// MarshalProfiles encodes profiles in the format read by ParseProfiles.
+// Profiles coming from ParseProfiles are parsed back unchanged; bitrates of
+// others come back in bits per second, without the "k" suffix.
+func MarshalProfiles(profiles []VideoProfile) ([]byte, error) {
+	jsonProfiles := make([]JsonProfile, len(profiles))
+	for i, p := range profiles {
+		jp, err := toJsonProfile(p)
+		if err != nil {
+			return nil, fmt.Errorf("profile %d: %w", i, err)
+		}
+		jsonProfiles[i] = jp
+	}
+	return json.Marshal(jsonProfiles)
+}
+
+func toJsonProfile(p VideoProfile) (JsonProfile, error) {
+	jp := JsonProfile{
+		Name:         p.Name,
+		FPS:          p.Framerate,
+		FPSDen:       p.FramerateDen,
+		ColorDepth:   p.ColorDepth,
+		ChromaFormat: p.ChromaFormat,
+		AspectRatio:  p.AspectRatio,
+		Passthrough:  p.Passthrough,
+	}
+	if p.Resolution != "" {
+		w, h, err := VideoProfileResolution(p)
+		if err != nil {
+			return jp, fmt.Errorf("invalid resolution: %s", p.Resolution)
+		}
+		jp.Width, jp.Height = w, h
+	}
+	var err error
+	if jp.EncoderOpts, err = EncoderOptsMap(p); err != nil {
+		return jp, fmt.Errorf("invalid encoder options: %s", p.EncoderOpts)
+	}
+	if jp.Bitrate, err = bitrateToInt(p.Bitrate); err != nil {
+		return jp, fmt.Errorf("invalid bitrate: %s", p.Bitrate)
+	}
+	switch {
+	case p.GOP == GOPIntraOnly:
+		jp.GOP = "intra"
+	case p.GOP < 0:
+		return jp, ErrTranscoderGOP
+	case p.GOP > 0:
+		jp.GOP = strconv.FormatFloat(p.GOP.Seconds(), 'f', -1, 64)
+	}
+	if p.Profile != ProfileNone {
+		for name, profile := range EncoderProfileLookup {
+			if profile == p.Profile {
+				jp.Profile = name
+			}
+		}
+		if jp.Profile == "" {
+			return jp, ErrProfName
+		}
+	}
+	if p.Level > 0 {
+		jp.Level = H264LevelName(p.Level)
+	}
+	var ok bool
+	if jp.Encoder, ok = VideoCodecName[p.Encoder]; !ok {
+		return jp, ErrCodecName
+	}
+	if p.FramerateMode != FramerateFixed {
+		for name, mode := range FramerateModeLookup {
+			if mode == p.FramerateMode {
+				jp.FPSMode = name
+			}
+		}
+		if jp.FPSMode == "" {
+			return jp, fmt.Errorf("unknown fps mode: %d", p.FramerateMode)
+		}
+	}
+	if p.Format != FormatNone {
+		for name, format := range FormatLookup {
+			if format == p.Format {
+				jp.Format = name
+			}
+		}
+		if jp.Format == "" {
+			return jp, ErrTranscoderFmt
+		}
+	}
+	if p.Accel != Software {
+		if jp.Accel, ok = AccelerationNameLookup[p.Accel]; !ok {
+			return jp, ErrAccelName
+		}
+	}
+	if p.Audio != (AudioProfile{}) {
+		jp.Audio = &JsonAudioProfile{Codec: p.Audio.Codec}
+		if jp.Audio.Bitrate, err = bitrateToInt(p.Audio.Bitrate); err != nil {
+			return jp, fmt.Errorf("invalid audio bitrate: %s", p.Audio.Bitrate)
+		}
+	}
+	return jp, nil
+}
+
+// EncoderOptsMap decodes the EncoderOpts of a profile; nil if there are none
+func EncoderOptsMap(p VideoProfile) (map[string]string, error) {
+	if p.EncoderOpts == "" {
+		return nil, nil
+	}
+	values, err := url.ParseQuery(p.EncoderOpts)
+	if err != nil {
+		return nil, err
+	}
+	opts := make(map[string]string, len(values))
+	for k, v := range values {
+		if k == "" {
+			return nil, fmt.Errorf("empty encoder option name")
+		}
+		opts[k] = v[len(v)-1]
+	}
+	return opts, nil
+}
+
+// bitrateToInt parses bitrates with an optional "k" suffix; empty is zero
+func bitrateToInt(bitrate string) (int, error) {
+	if bitrate == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(strings.Replace(bitrate, "k", "000", 1))
+}
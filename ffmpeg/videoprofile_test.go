@@ -0,0 +1,202 @@
+package ffmpeg
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVideoProfileCodecs(t *testing.T) {
+	tests := []struct {
+		profile VideoProfile
+		codecs  string
+	}{
+		{P720p30fps16x9, "avc1.64001F"},
+		{P720p60fps16x9, "avc1.640020"},
+		{P144p30fps16x9, "avc1.64000C"},
+		{VideoProfile{Resolution: "1920x1080", Framerate: 30, Profile: ProfileH264Main}, "avc1.4D4028"},
+		{VideoProfile{Resolution: "1920:1080", Framerate: 60, Profile: ProfileH264Baseline}, "avc1.42E02A"},
+		// unknown frame rate counts as 60fps
+		{VideoProfile{Resolution: "1920x1080"}, "avc1.64002A"},
+		{VideoProfile{Resolution: "30000/1001x1080"}, ""},
+		{VideoProfile{Resolution: "1920x1080", Framerate: 30, Encoder: H265}, "hvc1.1.6.L120.B0"},
+		{VideoProfile{Resolution: "3840x2160", Framerate: 60, Encoder: H265, ColorDepth: ColorDepth10Bit}, "hvc1.2.4.L153.B0"},
+		{VideoProfile{Resolution: "1280x720", Framerate: 30, Encoder: VP9}, "vp09.00.31.08"},
+		{VideoProfile{Resolution: "1280x720", Framerate: 30, Profile: ProfileH264High, Level: 41}, "avc1.640029"},
+		{VideoProfile{Resolution: "1280x720", Framerate: 30, Encoder: H265, Profile: ProfileH265Main10}, "hvc1.2.4.L93.B0"},
+		{VideoProfile{Resolution: "1280x720", Encoder: VP8}, "vp8"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.codecs, VideoProfileCodecs(tt.profile), tt.profile.Resolution)
+	}
+
+	params := VideoProfileToVariantParams(P360p30fps16x9)
+	assert.Equal(t, uint32(1200000), params.Bandwidth)
+	assert.Equal(t, "640x360", params.Resolution)
+	assert.Empty(t, params.Codecs)
+
+	assert.Equal(t, "avc1.64001E", VariantCodecs(P360p30fps16x9, ""))
+	assert.Equal(t, "avc1.64001E,mp4a.40.2", VariantCodecs(P360p30fps16x9, "aac"))
+	assert.Equal(t, "", VariantCodecs(P360p30fps16x9, "vorbis"), "incomplete list left out")
+	assert.Equal(t, "", VariantCodecs(VideoProfile{}, "aac"))
+
+	// what the stream signals wins over what the resolution suggests
+	format := MediaFormatInfo{Vcodec: "h264", Acodec: "aac", Width: 640, Height: 360, Profile: ProfileH264Main, Level: 40}
+	assert.Equal(t, "avc1.4D4028,mp4a.40.2", FormatCodecs(format))
+	format.Level, format.Framerate = 0, 30
+	assert.Equal(t, "avc1.4D401E,mp4a.40.2", FormatCodecs(format))
+	format = MediaFormatInfo{Vcodec: "hevc", Width: 1920, Height: 1080, Level: 123,
+		PixFormat: PixelFormat{PixelFormatYUV420P10LE}}
+	assert.Equal(t, "hvc1.2.4.L123.B0", FormatCodecs(format))
+	format.Vcodec = "mpeg2video"
+	assert.Equal(t, "", FormatCodecs(format))
+}
+
+func TestParseProfiles_FramerateMode(t *testing.T) {
+	profiles, err := ParseProfiles([]byte(`[
+		{"name": "capped", "width": 1280, "height": 720, "bitrate": 3000000, "fps": 30, "fpsMode": "max"},
+		{"name": "fixed", "width": 1280, "height": 720, "bitrate": 3000000, "fps": 30}
+	]`))
+	assert.NoError(t, err)
+	assert.Equal(t, FramerateMax, profiles[0].FramerateMode)
+	assert.Equal(t, FramerateFixed, profiles[1].FramerateMode)
+
+	_, err = ParseProfiles([]byte(`[{"name": "bad", "fps": 30, "fpsMode": "min"}]`))
+	assert.Error(t, err)
+}
+
+func TestParseProfiles_Extended(t *testing.T) {
+	profiles, err := ParseProfiles([]byte(`[{
+		"name": "full", "width": 1280, "height": 720, "bitrate": 3000000, "fps": 30,
+		"format": "mp4", "aspectRatio": "16:9", "accel": "nvidia",
+		"audio": {"codec": "libopus", "bitrate": 96000},
+		"encoderOpts": {"preset": "slow", "tune": "film"}
+	}]`))
+	require.NoError(t, err)
+	p := profiles[0]
+	assert.Equal(t, FormatMP4, p.Format)
+	assert.Equal(t, "16:9", p.AspectRatio)
+	assert.Equal(t, Nvidia, p.Accel)
+	assert.Equal(t, AudioProfile{Codec: "libopus", Bitrate: "96000"}, p.Audio)
+	assert.Equal(t, "preset=slow&tune=film", p.EncoderOpts)
+	opts, err := EncoderOptsMap(p)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"preset": "slow", "tune": "film"}, opts)
+
+	// the basic schema still parses the same
+	profiles, err = ParseProfiles([]byte(`[{"name": "basic", "width": 640, "height": 360, "bitrate": 1000000}]`))
+	require.NoError(t, err)
+	assert.Equal(t, []VideoProfile{{Name: "basic", Bitrate: "1000000", Resolution: "640x360"}}, profiles)
+
+	// errors name the offending profile
+	bad := []string{
+		`{"format": "avi"}`,
+		`{"aspectRatio": "wide"}`,
+		`{"aspectRatio": "16:0"}`,
+		`{"accel": "quantum"}`,
+		`{"audio": {"bitrate": -1}}`,
+		`{"encoderOpts": {"": "1"}}`,
+		`{"width": -640, "height": 360}`,
+		`{"bitrate": -1}`,
+		`{"encoder": "AV1"}`,
+		`{"gop": "-2"}`,
+	}
+	for _, b := range bad {
+		_, err = ParseProfiles([]byte(`[{"name": "ok"}, ` + b + `]`))
+		require.Error(t, err, b)
+		assert.True(t, strings.HasPrefix(err.Error(), "profile 1: "), err.Error())
+	}
+	_, err = ParseProfiles([]byte(`[{"accel": "quantum"}]`))
+	assert.True(t, errors.Is(err, ErrAccelName))
+}
+
+func TestMarshalProfiles(t *testing.T) {
+	in := `[
+		{"name": "a", "width": 1920, "height": 1080, "bitrate": 6000000, "fps": 30000, "fpsDen": 1001, "profile": "h264high", "gop": "2.5"},
+		{"name": "b", "width": 640, "height": 360, "bitrate": 1000000, "fps": 30, "fpsMode": "max", "encoder": "HEVC", "gop": "intra",
+		 "colorDepth": 10, "format": "mpegts", "aspectRatio": "16:9", "accel": "Netint",
+		 "audio": {"codec": "aac", "bitrate": 128000}, "encoderOpts": {"preset": "fast", "x264-params": "keyint=60:open-gop=0"}},
+		{"width": 256, "height": 144, "bitrate": 400000, "passthrough": true}
+	]`
+	profiles, err := ParseProfiles([]byte(in))
+	require.NoError(t, err)
+	out, err := MarshalProfiles(profiles)
+	require.NoError(t, err)
+	again, err := ParseProfiles(out)
+	require.NoError(t, err)
+	assert.Equal(t, profiles, again)
+
+	// sample profiles come back with plain bitrates
+	out, err = MarshalProfiles([]VideoProfile{P720p30fps16x9})
+	require.NoError(t, err)
+	again, err = ParseProfiles(out)
+	require.NoError(t, err)
+	expected := P720p30fps16x9
+	expected.Bitrate = "4000000"
+	assert.Equal(t, []VideoProfile{expected}, again)
+
+	bad := P144p30fps16x9
+	bad.Resolution = "wide"
+	_, err = MarshalProfiles([]VideoProfile{P144p30fps16x9, bad})
+	assert.EqualError(t, err, "profile 1: invalid resolution: wide")
+}
+
+func TestValidateCodecProfile(t *testing.T) {
+	tests := []struct {
+		profile VideoProfile
+		valid   bool
+	}{
+		{VideoProfile{}, true},
+		{VideoProfile{Profile: ProfileH264High, Level: 41}, true},
+		{VideoProfile{Profile: ProfileH264ConstrainedHigh, Encoder: H265}, false},
+		{VideoProfile{Profile: ProfileH264Main, ColorDepth: ColorDepth10Bit}, false},
+		{VideoProfile{Profile: ProfileH265Main, Encoder: H265}, true},
+		{VideoProfile{Profile: ProfileH265Main, Encoder: H265, ColorDepth: ColorDepth10Bit}, false},
+		{VideoProfile{Profile: ProfileH265Main10, Encoder: H265, ColorDepth: ColorDepth10Bit}, true},
+		{VideoProfile{Profile: ProfileH265Main10, Encoder: H265, ColorDepth: ColorDepth12Bit}, false},
+		{VideoProfile{Profile: ProfileH265Main10}, false},
+		{VideoProfile{Level: 41, Encoder: H265}, false},
+		{VideoProfile{Level: 43}, false},
+		{VideoProfile{Profile: 420}, false},
+	}
+	for i, tt := range tests {
+		err := ValidateCodecProfile(tt.profile)
+		if tt.valid {
+			assert.NoError(t, err, i)
+		} else {
+			assert.True(t, errors.Is(err, ErrTranscoderPrf), i)
+		}
+	}
+}
+
+func TestParseProfiles_Levels(t *testing.T) {
+	profiles, err := ParseProfiles([]byte(`[
+		{"width": 1280, "height": 720, "profile": "H264High", "level": "4.1"},
+		{"width": 1280, "height": 720, "profile": "H264Main", "level": "3"},
+		{"width": 1280, "height": 720, "encoder": "HEVC", "profile": "HEVCMain10", "colorDepth": 2}
+	]`))
+	require.NoError(t, err)
+	assert.Equal(t, 41, profiles[0].Level)
+	assert.Equal(t, 30, profiles[1].Level)
+	assert.Equal(t, ProfileH265Main10, profiles[2].Profile)
+
+	out, err := MarshalProfiles(profiles)
+	require.NoError(t, err)
+	again, err := ParseProfiles(out)
+	require.NoError(t, err)
+	assert.Equal(t, profiles, again)
+
+	for _, bad := range []string{
+		`{"level": "4.3"}`,
+		`{"level": "high"}`,
+		`{"encoder": "HEVC", "level": "4.1"}`,
+		`{"encoder": "HEVC", "profile": "h264high"}`,
+		`{"profile": "hevcmain"}`,
+	} {
+		_, err := ParseProfiles([]byte(`[` + bad + `]`))
+		assert.Error(t, err, bad)
+	}
+}
@@ -0,0 +1,238 @@
+package ffmpeg
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// #include <stdlib.h>
+// #include "extras.h"
+import "C"
+
+var ErrTranscoderVOD = errors.New("TranscoderUnsupportedVODOptions")
+
+// VODOptions configures TranscodeVOD
+type VODOptions struct {
+	// Number of chunks to split the input into. Defaults to the number of CPUs.
+	Chunks int
+	// Maximum number of chunks transcoded at once. Defaults to the number of CPUs.
+	Concurrency int
+	// Audio encoder, applied once to the whole input rather than per chunk so
+	// encoder priming isn't repeated at every chunk boundary. Defaults to AAC.
+	AudioEncoder ComponentOptions
+	// Directory under which each call keeps its intermediate chunks, in a
+	// subdirectory of its own that is left in place. Defaults to a temporary
+	// directory that is removed afterwards.
+	WorkDir string
+}
+
+// TranscodeVOD transcodes a file by splitting it at keyframes into chunks,
+// transcoding the chunks concurrently in separate sessions and concatenating
+// the chunks of each rendition into its output with continuous timestamps.
+// The output is frame-count identical to a serial transcode, resampled
+// renditions included: each chunk's frame rate filter carries on from the
+// frames of the chunks before it. The renditions' audio encoder is ignored in
+// favour of opts.AudioEncoder; clipping and detector profiles are not
+// supported.
+func TranscodeVOD(input *TranscodeOptionsIn, ps []TranscodeOptions, opts VODOptions) (*TranscodeResults, error) {
+	if input == nil || strings.HasPrefix(strings.ToLower(input.Fname), "pipe:") {
+		return nil, ErrTranscoderInp
+	}
+	for _, p := range ps {
+		if p.From != 0 || p.To != 0 || !p.Clip.IsZero() || p.Detector != nil {
+			return nil, ErrTranscoderVOD
+		}
+	}
+	status, format, err := GetCodecInfo(input.Fname)
+	if err != nil {
+		return nil, err
+	}
+	if status != CodecStatusOk || format.Vcodec == "" {
+		return nil, ErrTranscoderVid
+	}
+	chunks := opts.Chunks
+	if chunks <= 0 {
+		chunks = runtime.NumCPU()
+	}
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = runtime.NumCPU()
+	}
+	// a directory of our own, so chunks of other runs sharing the WorkDir
+	// don't end up in the output
+	workDir, err := ioutil.TempDir(opts.WorkDir, "lpms-vod")
+	if err != nil {
+		return nil, err
+	}
+	if opts.WorkDir == "" {
+		defer os.RemoveAll(workDir)
+	}
+
+	names, err := splitVOD(input.Fname, format.Duration, chunks, opts.AudioEncoder, workDir)
+	if err != nil {
+		return nil, err
+	}
+	offsets, err := vodFrameOffsets(names)
+	if err != nil {
+		return nil, err
+	}
+	audio := ComponentOptions{Name: "copy"}
+	if opts.AudioEncoder.Name == "drop" {
+		audio = opts.AudioEncoder
+	}
+	results, err := transcodeVODChunks(input, ps, names, offsets, audio, concurrency, workDir)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &TranscodeResults{Encoded: make([]MediaInfo, len(ps))}
+	for _, r := range results {
+		res.Decoded.Frames += r.Decoded.Frames
+		res.Decoded.Pixels += r.Decoded.Pixels
+		res.KeyframeMisalignments = append(res.KeyframeMisalignments, r.KeyframeMisalignments...)
+		res.Corruption.Packets += r.Corruption.Packets
+		res.Corruption.Frames += r.Corruption.Frames
+		res.Corruption.Dropped += r.Corruption.Dropped
+		res.Corruption.PTS = append(res.Corruption.PTS, r.Corruption.PTS...)
+		res.Discontinuities = append(res.Discontinuities, r.Discontinuities...)
+		for j := range ps {
+			res.Encoded[j].Frames += r.Encoded[j].Frames
+			res.Encoded[j].Pixels += r.Encoded[j].Pixels
+		}
+	}
+	for j, p := range ps {
+		chunkNames := make([]string, len(names))
+		for i := range names {
+			chunkNames[i] = vodChunkName(workDir, i, j)
+		}
+		if err := concatVOD(chunkNames, p); err != nil {
+			return nil, err
+		}
+		if p.CalcSign {
+			// signatures can't be concatenated, so compute it over the whole output
+			if _, err := GenerateSignatureFile(p.Oname, p.Oname+".bin"); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return res, nil
+}
+
+// splitVOD encodes the audio and splits the input at keyframes into chunks of
+// roughly equal duration, returning the chunk names in order
+func splitVOD(fname string, duration time.Duration, chunks int, audio ComponentOptions, workDir string) ([]string, error) {
+	segTime := duration / time.Duration(chunks)
+	if segTime <= 0 {
+		// unknown duration, keep everything in a single chunk
+		segTime = 24 * time.Hour
+	}
+	_, err := Transcode3(&TranscodeOptionsIn{Fname: fname}, []TranscodeOptions{{
+		Oname:        filepath.Join(workDir, "chunk-%05d.ts"),
+		Profile:      VideoProfile{Format: FormatNone},
+		VideoEncoder: ComponentOptions{Name: "copy"},
+		AudioEncoder: audio,
+		Muxer: ComponentOptions{Name: "segment", Opts: map[string]string{
+			"segment_format": "mpegts",
+			"segment_time":   strconv.FormatFloat(segTime.Seconds(), 'f', 6, 64),
+		}},
+	}})
+	if err != nil {
+		return nil, err
+	}
+	names, err := filepath.Glob(filepath.Join(workDir, "chunk-*.ts"))
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, ErrTranscoderVid
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// vodFrameOffsets returns the number of video frames before each chunk
+func vodFrameOffsets(names []string) ([]int, error) {
+	offsets := make([]int, len(names))
+	total := 0
+	for i, name := range names {
+		offsets[i] = total
+		cname := C.CString(name)
+		frames := int(C.lpms_video_frames(cname))
+		C.free(unsafe.Pointer(cname))
+		if frames < 0 {
+			return nil, ErrTranscoderVid
+		}
+		total += frames
+	}
+	return offsets, nil
+}
+
+func transcodeVODChunks(input *TranscodeOptionsIn, ps []TranscodeOptions, names []string, offsets []int, audio ComponentOptions, concurrency int, workDir string) ([]*TranscodeResults, error) {
+	pool := NewTranscoderPool(TranscoderPoolOptions{MaxSessions: concurrency})
+	defer pool.Stop()
+	results := make([]*TranscodeResults, len(names))
+	errs := make([]error, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			in := *input
+			in.Fname = name
+			in.frameOffset = offsets[i]
+			outs := make([]TranscodeOptions, len(ps))
+			for j, p := range ps {
+				p.Oname = vodChunkName(workDir, i, j)
+				p.Muxer = ComponentOptions{Name: "mpegts"}
+				p.AudioEncoder = audio
+				p.CalcSign = false
+				outs[j] = p
+			}
+			// chunks are independent streams; earlier ones are served first
+			id := strconv.Itoa(i)
+			results[i], errs[i] = pool.Transcode(id, len(names)-i, &in, outs)
+			pool.Release(id)
+		}(i, name)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("chunk %d: %w", i, err)
+		}
+	}
+	return results, nil
+}
+
+// concatVOD transmuxes the chunks of a rendition into its output
+func concatVOD(names []string, p TranscodeOptions) error {
+	tc := NewTranscoder()
+	defer tc.StopTranscoder()
+	out := []TranscodeOptions{{
+		Oname:        p.Oname,
+		Profile:      VideoProfile{Format: FormatNone},
+		VideoEncoder: ComponentOptions{Name: "copy"},
+		AudioEncoder: ComponentOptions{Name: "copy"},
+		Muxer:        p.Muxer,
+	}}
+	for _, name := range names {
+		_, err := tc.Transcode(&TranscodeOptionsIn{Fname: name, Transmuxing: true}, out)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func vodChunkName(workDir string, chunk, rendition int) string {
+	return filepath.Join(workDir, fmt.Sprintf("out-%05d-%d.ts", chunk, rendition))
+}
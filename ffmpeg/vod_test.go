@@ -0,0 +1,68 @@
+package ffmpeg
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTranscodeVOD(t *testing.T) {
+	run, dir := setupTest(t)
+	defer os.RemoveAll(dir)
+
+	prof := P144p30fps16x9
+	prof.Framerate = 0 // keep the source frame rate
+	in := &TranscodeOptionsIn{Fname: "../transcoder/test.ts"}
+	serial, err := Transcode3(in, []TranscodeOptions{{
+		Oname:   dir + "/serial.ts",
+		Profile: prof,
+	}, {
+		Oname:   dir + "/serial-240p.ts",
+		Profile: P240p30fps16x9,
+	}})
+	require.NoError(t, err)
+
+	res, err := TranscodeVOD(in, []TranscodeOptions{{
+		Oname:   dir + "/vod.ts",
+		Profile: prof,
+	}, {
+		Oname:    dir + "/vod.mp4",
+		Profile:  P240p30fps16x9,
+		CalcSign: true,
+	}}, VODOptions{Chunks: 4, Concurrency: 2, WorkDir: dir})
+	require.NoError(t, err)
+	assert.Equal(t, serial.Decoded.Frames, res.Decoded.Frames)
+	assert.Equal(t, serial.Encoded[0].Frames, res.Encoded[0].Frames)
+	assert.Equal(t, serial.Encoded[0].Pixels, res.Encoded[0].Pixels)
+	// resampled renditions too
+	assert.Equal(t, serial.Encoded[1].Frames, res.Encoded[1].Frames)
+	assert.Equal(t, serial.Encoded[1].Pixels, res.Encoded[1].Pixels)
+
+	cmd := `
+    # input was actually split into several chunks
+    ls lpms-vod*/chunk-00003.ts
+    # same frame count in the concatenated output
+    ffprobe -loglevel warning -select_streams v -count_frames -show_streams serial.ts | grep nb_read_frames > serial.frames
+    ffprobe -loglevel warning -select_streams v -count_frames -show_streams vod.ts | grep nb_read_frames > vod.frames
+    diff serial.frames vod.frames
+    ffprobe -loglevel warning -select_streams v -count_frames -show_streams serial-240p.ts | grep nb_read_frames > serial-240p.frames
+    ffprobe -loglevel warning -select_streams v -count_frames -show_streams vod.mp4 | grep nb_read_frames > vod-240p.frames
+    diff serial-240p.frames vod-240p.frames
+    # both video and audio made it into the mp4
+    ffprobe -loglevel warning -show_format vod.mp4 | grep nb_streams=2
+    ffprobe -loglevel warning -show_format vod.mp4 | grep format_name=mov
+    ls vod.mp4.bin
+  `
+	assert.True(t, run(cmd))
+
+	_, err = TranscodeVOD(in, []TranscodeOptions{{
+		Oname:   dir + "/clip.ts",
+		Profile: prof,
+		From:    1,
+	}}, VODOptions{})
+	assert.Equal(t, ErrTranscoderVOD, err)
+	_, err = TranscodeVOD(&TranscodeOptionsIn{Fname: "pipe:0"}, nil, VODOptions{})
+	assert.Equal(t, ErrTranscoderInp, err)
+}
@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+)
+
+// Memory keeps all measurements, for tests
+type Memory struct {
+	mu sync.Mutex
+	s  MemorySnapshot
+}
+
+// MemorySnapshot holds the measurements kept by Memory
+type MemorySnapshot struct {
+	Stages            map[string][]time.Duration
+	RealtimeRatios    []float64
+	FPS               []float64
+	Errors            map[int]int
+	SessionsCreated   int
+	SessionsReused    int
+	SegmenterTimeouts int
+	HLSSegmentAdds    []time.Duration
+}
+
+func NewMemory() *Memory {
+	return &Memory{s: MemorySnapshot{
+		Stages: map[string][]time.Duration{},
+		Errors: map[int]int{},
+	}}
+}
+
+// Snapshot returns a copy of the measurements so far
+func (m *Memory) Snapshot() MemorySnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.s
+	s.Stages = make(map[string][]time.Duration, len(m.s.Stages))
+	for k, v := range m.s.Stages {
+		s.Stages[k] = append([]time.Duration(nil), v...)
+	}
+	s.Errors = make(map[int]int, len(m.s.Errors))
+	for k, v := range m.s.Errors {
+		s.Errors[k] = v
+	}
+	s.RealtimeRatios = append([]float64(nil), m.s.RealtimeRatios...)
+	s.FPS = append([]float64(nil), m.s.FPS...)
+	s.HLSSegmentAdds = append([]time.Duration(nil), m.s.HLSSegmentAdds...)
+	return s
+}
+
+func (m *Memory) TranscodeStage(stage string, d time.Duration) {
+	m.mu.Lock()
+	m.s.Stages[stage] = append(m.s.Stages[stage], d)
+	m.mu.Unlock()
+}
+
+func (m *Memory) TranscodeRealtimeRatio(ratio float64) {
+	m.mu.Lock()
+	m.s.RealtimeRatios = append(m.s.RealtimeRatios, ratio)
+	m.mu.Unlock()
+}
+
+func (m *Memory) TranscodeFPS(fps float64) {
+	m.mu.Lock()
+	m.s.FPS = append(m.s.FPS, fps)
+	m.mu.Unlock()
+}
+
+func (m *Memory) TranscodeError(code int) {
+	m.mu.Lock()
+	m.s.Errors[code]++
+	m.mu.Unlock()
+}
+
+func (m *Memory) TranscodeSession(reused bool) {
+	m.mu.Lock()
+	if reused {
+		m.s.SessionsReused++
+	} else {
+		m.s.SessionsCreated++
+	}
+	m.mu.Unlock()
+}
+
+func (m *Memory) SegmenterTimeout() {
+	m.mu.Lock()
+	m.s.SegmenterTimeouts++
+	m.mu.Unlock()
+}
+
+func (m *Memory) HLSSegmentAdd(d time.Duration) {
+	m.mu.Lock()
+	m.s.HLSSegmentAdds = append(m.s.HLSSegmentAdds, d)
+	m.mu.Unlock()
+}
@@ -0,0 +1,72 @@
+// Package metrics collects measurements of the transcoder, segmenter and
+// HLS streams, so they can be exported without scraping logs.
+package metrics
+
+import (
+	"sync"
+	"time"
+)
+
+// Stages of a transcode, as timed by TranscodeStage
+const (
+	// Reading the input format
+	StageProbe = "probe"
+	// Checking options and configuring the outputs
+	StageSetup = "setup"
+	// Reopening the demuxer on audio changes
+	StageReopenDemux = "reopen_demux"
+	// Decoding, filtering, encoding and muxing
+	StageTranscode = "transcode"
+)
+
+// Metrics receives the measurements. Implementations must be safe for
+// concurrent use.
+type Metrics interface {
+	// Time taken by a stage of a transcode
+	TranscodeStage(stage string, d time.Duration)
+	// Input duration over transcode time, for inputs of known duration
+	TranscodeRealtimeRatio(ratio float64)
+	// Decoded frames per second of transcode time
+	TranscodeFPS(fps float64)
+	// Transcode failure, by ffmpeg.ErrorMap code
+	TranscodeError(code int)
+	// Transcode on a new session, or on one used before
+	TranscodeSession(reused bool)
+	// Segmenter poll giving up with segmenter.ErrSegmenterTimeout
+	SegmenterTimeout()
+	// Time taken adding a segment to an HLS stream
+	HLSSegmentAdd(d time.Duration)
+}
+
+var (
+	mu      sync.RWMutex
+	current Metrics = Nop{}
+)
+
+// Set makes m receive the measurements of all packages; nil turns them off
+func Set(m Metrics) {
+	if m == nil {
+		m = Nop{}
+	}
+	mu.Lock()
+	current = m
+	mu.Unlock()
+}
+
+// Current returns the Metrics given to Set, Nop if none
+func Current() Metrics {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current
+}
+
+// Nop drops all measurements
+type Nop struct{}
+
+func (Nop) TranscodeStage(string, time.Duration) {}
+func (Nop) TranscodeRealtimeRatio(float64)       {}
+func (Nop) TranscodeFPS(float64)                 {}
+func (Nop) TranscodeError(int)                   {}
+func (Nop) TranscodeSession(bool)                {}
+func (Nop) SegmenterTimeout()                    {}
+func (Nop) HLSSegmentAdd(time.Duration)          {}
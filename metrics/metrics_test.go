@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetCurrent(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(Nop{}, Current())
+	m := NewMemory()
+	Set(m)
+	assert.Equal(m, Current())
+	Set(nil)
+	assert.Equal(Nop{}, Current())
+}
+
+func TestMemory(t *testing.T) {
+	assert := assert.New(t)
+	m := NewMemory()
+	m.TranscodeStage(StageProbe, time.Millisecond)
+	m.TranscodeStage(StageTranscode, time.Second)
+	m.TranscodeStage(StageTranscode, 2*time.Second)
+	m.TranscodeRealtimeRatio(4)
+	m.TranscodeFPS(240)
+	m.TranscodeError(-2)
+	m.TranscodeError(-2)
+	m.TranscodeSession(false)
+	m.TranscodeSession(true)
+	m.TranscodeSession(true)
+	m.SegmenterTimeout()
+	m.HLSSegmentAdd(time.Millisecond)
+
+	s := m.Snapshot()
+	assert.Equal(map[string][]time.Duration{
+		StageProbe:     {time.Millisecond},
+		StageTranscode: {time.Second, 2 * time.Second},
+	}, s.Stages)
+	assert.Equal([]float64{4}, s.RealtimeRatios)
+	assert.Equal([]float64{240}, s.FPS)
+	assert.Equal(map[int]int{-2: 2}, s.Errors)
+	assert.Equal(1, s.SessionsCreated)
+	assert.Equal(2, s.SessionsReused)
+	assert.Equal(1, s.SegmenterTimeouts)
+	assert.Equal([]time.Duration{time.Millisecond}, s.HLSSegmentAdds)
+
+	// snapshots don't change with later measurements
+	m.TranscodeStage(StageProbe, time.Millisecond)
+	m.TranscodeError(-2)
+	assert.Len(s.Stages[StageProbe], 1)
+	assert.Equal(2, s.Errors[-2])
+}
+
+type fakeRegistry map[string][]string
+
+type fakeMetric struct {
+	r    fakeRegistry
+	name string
+}
+
+func (f fakeRegistry) Counter(name, help string, labels ...string) Counter {
+	return fakeMetric{f, name}
+}
+
+func (f fakeRegistry) Histogram(name, help string, labels ...string) Histogram {
+	return fakeMetric{f, name}
+}
+
+func (m fakeMetric) Inc(labelValues ...string) {
+	m.r[m.name] = append(m.r[m.name], strings.Join(labelValues, ","))
+}
+
+func (m fakeMetric) Observe(value float64, labelValues ...string) {
+	m.r[m.name] = append(m.r[m.name], strings.Join(append(labelValues, time.Duration(value*float64(time.Second)).String()), ","))
+}
+
+func TestRegistryMetrics(t *testing.T) {
+	r := fakeRegistry{}
+	m := NewRegistryMetrics(r)
+	m.TranscodeStage(StageSetup, 500*time.Millisecond)
+	m.TranscodeError(-1094995529)
+	m.TranscodeSession(false)
+	m.TranscodeSession(true)
+	m.SegmenterTimeout()
+	m.HLSSegmentAdd(2 * time.Second)
+	assert.Equal(t, fakeRegistry{
+		"lpms_transcode_stage_seconds":  {"setup,500ms"},
+		"lpms_transcode_errors_total":   {"-1094995529"},
+		"lpms_transcode_sessions_total": {"created", "reused"},
+		"lpms_segmenter_timeouts_total": {""},
+		"lpms_hls_segment_add_seconds":  {"2s"},
+	}, r)
+}
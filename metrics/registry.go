@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+)
+
+// Registry creates labelled metrics in a Prometheus-style registry. With the
+// Prometheus client, Counter and Histogram register a CounterVec or
+// HistogramVec and return it wrapped to call WithLabelValues.
+type Registry interface {
+	Counter(name, help string, labels ...string) Counter
+	Histogram(name, help string, labels ...string) Histogram
+}
+
+type Counter interface {
+	Inc(labelValues ...string)
+}
+
+type Histogram interface {
+	Observe(value float64, labelValues ...string)
+}
+
+type registryMetrics struct {
+	stage, realtime, fps, segmentAdd Histogram
+	errors, sessions, timeouts       Counter
+}
+
+// NewRegistryMetrics reports to metrics created in r. Durations are observed
+// in seconds.
+func NewRegistryMetrics(r Registry) Metrics {
+	return &registryMetrics{
+		stage:      r.Histogram("lpms_transcode_stage_seconds", "Time taken by each stage of a transcode", "stage"),
+		realtime:   r.Histogram("lpms_transcode_realtime_ratio", "Input duration over transcode time"),
+		fps:        r.Histogram("lpms_transcode_fps", "Decoded frames per second of transcode time"),
+		errors:     r.Counter("lpms_transcode_errors_total", "Transcode failures by error code", "code"),
+		sessions:   r.Counter("lpms_transcode_sessions_total", "Transcodes by whether their session was new or reused", "session"),
+		timeouts:   r.Counter("lpms_segmenter_timeouts_total", "Segmenter polls that timed out"),
+		segmentAdd: r.Histogram("lpms_hls_segment_add_seconds", "Time taken adding a segment to an HLS stream"),
+	}
+}
+
+func (m *registryMetrics) TranscodeStage(stage string, d time.Duration) {
+	m.stage.Observe(d.Seconds(), stage)
+}
+
+func (m *registryMetrics) TranscodeRealtimeRatio(ratio float64) {
+	m.realtime.Observe(ratio)
+}
+
+func (m *registryMetrics) TranscodeFPS(fps float64) {
+	m.fps.Observe(fps)
+}
+
+func (m *registryMetrics) TranscodeError(code int) {
+	m.errors.Inc(strconv.Itoa(code))
+}
+
+func (m *registryMetrics) TranscodeSession(reused bool) {
+	session := "created"
+	if reused {
+		session = "reused"
+	}
+	m.sessions.Inc(session)
+}
+
+func (m *registryMetrics) SegmenterTimeout() {
+	m.timeouts.Inc()
+}
+
+func (m *registryMetrics) HLSSegmentAdd(d time.Duration) {
+	m.segmentAdd.Observe(d.Seconds())
+}
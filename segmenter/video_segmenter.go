@@ -14,6 +14,7 @@ import (
 	"path"
 
 	"github.com/eliteprox/lpms/ffmpeg"
+	"github.com/eliteprox/lpms/metrics"
 	"github.com/eliteprox/lpms/stream"
 	"github.com/golang/glog"
 	"github.com/livepeer/joy4/av"
@@ -202,6 +203,7 @@ func (s *FFMpegVideoSegmenter) pollPlaylist(ctx context.Context, fn string, slee
 		}
 
 		if s.curPlWaitTime >= 10*s.SegLen {
+			metrics.Current().SegmenterTimeout()
 			return nil, ErrSegmenterTimeout
 		}
 		time.Sleep(sleepTime)
@@ -230,6 +232,7 @@ func (s *FFMpegVideoSegmenter) pollSegment(ctx context.Context, curFn string, ne
 		default:
 		}
 		if s.curSegWaitTime > 10*s.SegLen {
+			metrics.Current().SegmenterTimeout()
 			return nil, ErrSegmenterTimeout
 		}
 